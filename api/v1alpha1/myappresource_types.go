@@ -17,6 +17,8 @@ limitations under the License.
 package v1alpha1
 
 import (
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -33,8 +35,88 @@ type MyAppResourceSpec struct {
 	Image        Image             `json:"image,omitempty"`
 	UI           UI                `json:"ui,omitempty"`
 	Redis        Redis             `json:"redis,omitempty"`
+	Scheduling   Scheduling        `json:"scheduling,omitempty"`
+
+	SecurityContext SecurityContext `json:"securityContext,omitempty"`
+	ServiceAccount  ServiceAccount  `json:"serviceAccount,omitempty"`
+	NetworkPolicy   NetworkPolicy   `json:"networkPolicy,omitempty"`
+
+	// Env is added to the podinfo container. Entries override the variables
+	// derived from UI; PODINFO_CACHE_SERVER and POD_IP are reserved for the
+	// controller and cannot be set.
+	Env []corev1.EnvVar `json:"env,omitempty"`
+	// EnvFrom is added to the podinfo container. As usual, variables set
+	// through Env take precedence over the ones read from these sources.
+	EnvFrom []corev1.EnvFromSource `json:"envFrom,omitempty"`
+
+	// Volumes are added to the pod and can be mounted into the podinfo
+	// container through VolumeMounts.
+	Volumes      []Volume             `json:"volumes,omitempty"`
+	VolumeMounts []corev1.VolumeMount `json:"volumeMounts,omitempty"`
+	Persistence  Persistence          `json:"persistence,omitempty"`
+
+	// Profile selects the renderer turning this resource into objects.
+	// "podinfo", the default, runs podinfo configured through UI and
+	// Podinfo, and "podinfo-redis" adds a redis cache; Redis.Enabled picks
+	// it when Profile is empty or "podinfo". "generic" runs Image with the
+	// command, args and ports given in App. Custom builds of the manager
+	// may register more profiles.
+	Profile string  `json:"profile,omitempty"`
+	App     App     `json:"app,omitempty"`
+	Podinfo Podinfo `json:"podinfo,omitempty"`
+
+	// Sidecars run next to the application for the lifetime of the pod.
+	// On clusters supporting native sidecars (Kubernetes 1.29 and later)
+	// they are started as init containers with restartPolicy Always, before
+	// InitContainers; elsewhere they are added to the pod's containers.
+	Sidecars []corev1.Container `json:"sidecars,omitempty"`
+	// InitContainers run to completion, in order, before the application
+	// starts. Entries may set restartPolicy Always to run as native
+	// sidecars on clusters supporting them.
+	InitContainers []corev1.Container `json:"initContainers,omitempty"`
+
+	// Overrides patch the rendered objects before they are applied, for
+	// the fields this resource does not model.
+	Overrides []Override `json:"overrides,omitempty"`
+
+	// AdoptExisting lets the controller take over existing objects with the
+	// names it renders, provided they carry the label my.api.group/adopt set
+	// to the name of this resource and have no other controller. Without
+	// it, such objects are left alone and reported as a ResourceConflict.
+	AdoptExisting bool `json:"adoptExisting,omitempty"`
+
+	// NameOverride is appended to the name of this resource to name the
+	// objects it renders, and FullnameOverride replaces that name entirely.
+	// Changing either renames the objects: the new ones are created before
+	// the old ones are deleted.
+	// +kubebuilder:validation:Pattern=`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`
+	NameOverride string `json:"nameOverride,omitempty"`
+	// +kubebuilder:validation:Pattern=`^[a-z]([-a-z0-9]*[a-z0-9])?$`
+	FullnameOverride string `json:"fullnameOverride,omitempty"`
+	Naming           Naming `json:"naming,omitempty"`
+
+	// DeletionPolicy decides what happens to the objects of this resource
+	// when it is deleted.
+	// +kubebuilder:default=Delete
+	DeletionPolicy DeletionPolicy `json:"deletionPolicy,omitempty"`
 }
 
+// DeletionPolicy is what happens to the objects of a MyAppResource when it is
+// deleted.
+// +kubebuilder:validation:Enum=Delete;Orphan;Retain
+type DeletionPolicy string
+
+const (
+	// DeletionPolicyDelete stops the pods, then deletes every object.
+	DeletionPolicyDelete DeletionPolicy = "Delete"
+	// DeletionPolicyOrphan leaves every object in place, with the pods
+	// running.
+	DeletionPolicyOrphan DeletionPolicy = "Orphan"
+	// DeletionPolicyRetain stops the pods, then deletes every object but
+	// the PersistentVolumeClaims and Secrets.
+	DeletionPolicyRetain DeletionPolicy = "Retain"
+)
+
 type RequestsAndLimits struct {
 	MemoryRequest string `json:"memoryRequest,omitempty"`
 	MemoryLimit   string `json:"memoryLimit,omitempty"`
@@ -50,16 +132,241 @@ type Image struct {
 type UI struct {
 	Color   string `json:"color,omitempty"`
 	Message string `json:"message,omitempty"`
+	Logo    string `json:"logo,omitempty"`
 }
 
 type Redis struct {
 	Enabled bool `json:"enabled,omitempty"`
+
+	// SnapshotClaimName names an existing PersistentVolumeClaim redis
+	// saves its data to, as redis.rdb, whenever it stops. When this
+	// resource is deleted, its pods are stopped first, leaving a final
+	// snapshot in the claim.
+	SnapshotClaimName string `json:"snapshotClaimName,omitempty"`
+}
+
+// App describes the main container. With the podinfo profile, the fields
+// left empty are filled in from the podinfo settings.
+type App struct {
+	Command []string               `json:"command,omitempty"`
+	Args    []string               `json:"args,omitempty"`
+	Ports   []corev1.ContainerPort `json:"ports,omitempty"`
+}
+
+// OverrideType is the kind of patch of an Override.
+// +kubebuilder:validation:Enum=StrategicMerge;JSON
+type OverrideType string
+
+const (
+	// StrategicMergeOverride patches with a strategic merge patch, merging
+	// lists such as containers by their key.
+	StrategicMergeOverride OverrideType = "StrategicMerge"
+	// JSONOverride patches with a list of RFC 6902 JSON patch operations.
+	JSONOverride OverrideType = "JSON"
+)
+
+// Naming wraps the names of the rendered objects. Names longer than 63
+// characters, the limit of Service names and label values, are shortened and
+// suffixed with a hash of the full name.
+type Naming struct {
+	// +kubebuilder:validation:Pattern=`^[a-z]([-a-z0-9]*)?$`
+	Prefix string `json:"prefix,omitempty"`
+	// +kubebuilder:validation:Pattern=`^([-a-z0-9]*[a-z0-9])?$`
+	Suffix string `json:"suffix,omitempty"`
+}
+
+// Override patches the rendered objects matching Target.
+type Override struct {
+	Target OverrideTarget `json:"target"`
+	// +kubebuilder:default=StrategicMerge
+	Type OverrideType `json:"type,omitempty"`
+	// Patch is the patch document, in YAML or JSON.
+	// +kubebuilder:validation:MinLength=1
+	Patch string `json:"patch"`
+}
+
+// OverrideTarget selects rendered objects by kind and, optionally, name.
+type OverrideTarget struct {
+	// Kind of the objects, e.g. Deployment or Service.
+	// +kubebuilder:validation:MinLength=1
+	Kind string `json:"kind"`
+	// Name of the object. When empty, every rendered object of Kind is
+	// patched.
+	Name string `json:"name,omitempty"`
+}
+
+// Podinfo maps to the command line flags of podinfo.
+type Podinfo struct {
+	// +kubebuilder:validation:Enum=debug;info;warn;error
+	LogLevel string `json:"logLevel,omitempty"`
+
+	// HTTPPort is the port podinfo serves HTTP on. Defaults to 9898.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	HTTPPort int32 `json:"httpPort,omitempty"`
+
+	// GRPCPort enables the gRPC server on this port.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	GRPCPort int32 `json:"grpcPort,omitempty"`
+
+	// SecurePort enables HTTPS on this port, using the tls.crt and tls.key
+	// files found in CertPath.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	SecurePort int32  `json:"securePort,omitempty"`
+	CertPath   string `json:"certPath,omitempty"`
+
+	// BackendURLs are called by podinfo's /echo endpoint.
+	BackendURLs []string `json:"backendURLs,omitempty"`
+
+	RandomDelay *RandomDelay `json:"randomDelay,omitempty"`
+	// RandomError makes podinfo fail a random share of its requests.
+	RandomError bool `json:"randomError,omitempty"`
+}
+
+// RandomDelay makes podinfo wait a random time before answering requests.
+type RandomDelay struct {
+	// +kubebuilder:validation:Minimum=0
+	Min int32 `json:"min,omitempty"`
+	// +kubebuilder:validation:Minimum=0
+	Max int32 `json:"max,omitempty"`
+	// +kubebuilder:validation:Enum=s;ms
+	Unit string `json:"unit,omitempty"`
+}
+
+// Scheduling controls where the pods of a MyAppResource are placed.
+type Scheduling struct {
+	NodeSelector              map[string]string                 `json:"nodeSelector,omitempty"`
+	Tolerations               []corev1.Toleration               `json:"tolerations,omitempty"`
+	Affinity                  *corev1.Affinity                  `json:"affinity,omitempty"`
+	TopologySpreadConstraints []corev1.TopologySpreadConstraint `json:"topologySpreadConstraints,omitempty"`
+	PriorityClassName         string                            `json:"priorityClassName,omitempty"`
+	RuntimeClassName          *string                           `json:"runtimeClassName,omitempty"`
+
+	// SpreadAcrossZones adds zone and hostname topology spread constraints
+	// for this instance's pods. A constraint listed in
+	// TopologySpreadConstraints for the same topology key takes precedence.
+	SpreadAcrossZones bool `json:"spreadAcrossZones,omitempty"`
+}
+
+// SecurityContext overrides the hardened security settings the controller
+// applies to the pod and to every container it generates. Fields set here
+// replace the matching default; fields left unset keep it.
+type SecurityContext struct {
+	Pod       *corev1.PodSecurityContext `json:"pod,omitempty"`
+	Container *corev1.SecurityContext    `json:"container,omitempty"`
+
+	// PodSecurityLevel is the Pod Security Standard the generated pod must
+	// meet. Defaults to restricted.
+	// +kubebuilder:validation:Enum=privileged;baseline;restricted
+	PodSecurityLevel string `json:"podSecurityLevel,omitempty"`
+}
+
+// ServiceAccount selects the identity the pods run as.
+type ServiceAccount struct {
+	// Create makes the controller create and own a ServiceAccount for this
+	// instance.
+	Create bool `json:"create,omitempty"`
+
+	// Name of the ServiceAccount to create or use. Defaults to the name of
+	// the MyAppResource when Create is set, and to the namespace's default
+	// ServiceAccount otherwise.
+	Name string `json:"name,omitempty"`
+
+	// Annotations set on the created ServiceAccount, e.g. for workload
+	// identity.
+	Annotations map[string]string `json:"annotations,omitempty"`
+
+	AutomountToken *bool `json:"automountToken,omitempty"`
+
+	// Roles are bound to the created ServiceAccount with one RoleBinding
+	// each.
+	Roles []RoleReference `json:"roles,omitempty"`
+}
+
+// RoleReference names a Role in the namespace of the MyAppResource, or a
+// ClusterRole, to bind to its ServiceAccount.
+type RoleReference struct {
+	// +kubebuilder:validation:Enum=Role;ClusterRole
+	// +kubebuilder:default=Role
+	Kind string `json:"kind,omitempty"`
+	Name string `json:"name"`
+}
+
+// Volume is a pod volume backed by exactly one of the supported sources.
+type Volume struct {
+	Name string `json:"name"`
+
+	ConfigMap             *corev1.ConfigMapVolumeSource             `json:"configMap,omitempty"`
+	Secret                *corev1.SecretVolumeSource                `json:"secret,omitempty"`
+	EmptyDir              *corev1.EmptyDirVolumeSource              `json:"emptyDir,omitempty"`
+	Projected             *corev1.ProjectedVolumeSource             `json:"projected,omitempty"`
+	PersistentVolumeClaim *corev1.PersistentVolumeClaimVolumeSource `json:"persistentVolumeClaim,omitempty"`
+}
+
+// Persistence makes the controller create and own a PersistentVolumeClaim
+// mounted at podinfo's data directory. Disabling it again does not delete
+// the claim.
+type Persistence struct {
+	Enabled bool `json:"enabled,omitempty"`
+
+	// Size of the claim. Defaults to 1Gi. Can be increased later if the
+	// storage class allows volume expansion.
+	Size             string                              `json:"size,omitempty"`
+	StorageClassName *string                             `json:"storageClassName,omitempty"`
+	AccessModes      []corev1.PersistentVolumeAccessMode `json:"accessModes,omitempty"`
+}
+
+// NetworkPolicy restricts the traffic to and from the pods of a
+// MyAppResource.
+type NetworkPolicy struct {
+	// Enabled makes the controller generate and own NetworkPolicies for
+	// this instance. With redis, a second policy admits only the pods of
+	// this instance to the redis port. Redis always runs in the pods of
+	// the instance, so there are no separate redis pods to isolate.
+	Enabled bool `json:"enabled,omitempty"`
+
+	// Ingress lists the namespaces, pods and IP blocks allowed to reach the
+	// ports podinfo listens on. When empty, podinfo accepts no traffic from
+	// outside the pod.
+	Ingress []networkingv1.NetworkPolicyPeer `json:"ingress,omitempty"`
+
+	// Egress lists the destinations the pods may reach. DNS is always
+	// allowed. When empty, egress is not restricted.
+	Egress []networkingv1.NetworkPolicyEgressRule `json:"egress,omitempty"`
 }
 
 // MyAppResourceStatus defines the observed state of MyAppResource
 type MyAppResourceStatus struct {
 	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
+
+	// AppliedOverrides lists the patches from spec.overrides applied in the
+	// last reconciliation, one entry per patched object.
+	AppliedOverrides []AppliedOverride `json:"appliedOverrides,omitempty"`
+
+	// Conditions describe the state of the resource. ResourceConflict is
+	// true while a rendered object exists that the controller may not take
+	// over.
+	// +listType=map
+	// +listMapKey=type
+	// +patchStrategy=merge
+	// +patchMergeKey=type
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
+}
+
+// AppliedOverride records a patch applied to a rendered object.
+type AppliedOverride struct {
+	// Index of the entry in spec.overrides.
+	Index int32        `json:"index"`
+	Kind  string       `json:"kind"`
+	Name  string       `json:"name"`
+	Type  OverrideType `json:"type"`
+	// Digest is the sha256 of the patch, to tell which revision of it was
+	// applied.
+	Digest string `json:"digest"`
 }
 
 //+kubebuilder:object:root=true
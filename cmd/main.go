@@ -17,28 +17,50 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"flag"
+	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/discovery"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
 
 	myv1alpha1 "github.com/shilohstuart6/Custom-Controller.git/api/v1alpha1"
+	"github.com/shilohstuart6/Custom-Controller.git/internal/config"
 	"github.com/shilohstuart6/Custom-Controller.git/internal/controller"
+	"github.com/shilohstuart6/Custom-Controller.git/internal/health"
+	"github.com/shilohstuart6/Custom-Controller.git/internal/sharding"
+	"github.com/shilohstuart6/Custom-Controller.git/internal/tracing"
+	webhookv1alpha1 "github.com/shilohstuart6/Custom-Controller.git/internal/webhook/v1alpha1"
+	"github.com/shilohstuart6/Custom-Controller.git/pkg/render"
+	// Import packages registering additional render profiles here, e.g.
+	// _ "example.com/platform/profiles"
 	//+kubebuilder:scaffold:imports
 )
 
+// leaderElectionID names the leader election Lease.
+const leaderElectionID = "a921a039.api.group"
+
 var (
 	scheme   = runtime.NewScheme()
 	setupLog = ctrl.Log.WithName("setup")
@@ -57,15 +79,58 @@ func main() {
 	var probeAddr string
 	var secureMetrics bool
 	var enableHTTP2 bool
+	var configFile string
+	var maxConcurrentReconciles int
+	var kubeAPIQPS float64
+	var kubeAPIBurst int
+	var syncPeriod time.Duration
+	var featureGates string
+	var watchNamespaces string
+	var namespaceSelector string
+	var enableSharding bool
+	var shardName string
+	var shardLeaseNamespace string
+	var otlpEndpoint string
+	var traceSampleRatio float64
+	var leaderElectionNamespace string
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.StringVar(&leaderElectionNamespace, "leader-election-namespace", os.Getenv("POD_NAMESPACE"),
+		"The namespace of the leader election Lease. Defaults to $POD_NAMESPACE, or the namespace of the "+
+			"service account of the manager.")
 	flag.BoolVar(&secureMetrics, "metrics-secure", false,
 		"If set the metrics endpoint is served securely")
 	flag.BoolVar(&enableHTTP2, "enable-http2", false,
 		"If set, HTTP/2 will be enabled for the metrics and webhook servers")
+	flag.StringVar(&configFile, "config", "",
+		"The controller configuration file. Flags setting the same values take precedence over it.")
+	flag.IntVar(&maxConcurrentReconciles, "max-concurrent-reconciles", 1,
+		"The number of MyAppResources reconciled in parallel.")
+	flag.Float64Var(&kubeAPIQPS, "kube-api-qps", 0, "The queries per second allowed to the API server.")
+	flag.IntVar(&kubeAPIBurst, "kube-api-burst", 0, "The burst of queries allowed to the API server.")
+	flag.DurationVar(&syncPeriod, "sync-period", 10*time.Hour,
+		"How often every watched object is reconciled again.")
+	flag.StringVar(&featureGates, "feature-gates", "",
+		"A comma-separated list of name=true|false pairs turning features on or off.")
+	flag.StringVar(&watchNamespaces, "watch-namespaces", "",
+		"A comma-separated list of namespaces to watch. All namespaces are watched unless it or "+
+			"--namespace-selector is set.")
+	flag.StringVar(&namespaceSelector, "namespace-selector", "",
+		"A label selector adding the namespaces it matches to the watched namespaces.")
+	flag.BoolVar(&enableSharding, "sharding", false,
+		"Spread the MyAppResources over all replicas of the manager. Requires --leader-elect.")
+	flag.StringVar(&shardName, "shard-name", os.Getenv("POD_NAME"),
+		"The name of the shard of this replica, unique among the replicas. Defaults to $POD_NAME.")
+	flag.StringVar(&shardLeaseNamespace, "shard-lease-namespace", os.Getenv("POD_NAMESPACE"),
+		"The namespace of the Leases of the shards. Defaults to $POD_NAMESPACE.")
+	flag.StringVar(&otlpEndpoint, "otlp-endpoint", "",
+		"The URL of an OTLP/HTTP collector to send traces to, e.g. http://otel-collector:4318. "+
+			"Tracing is off when empty.")
+	flag.Float64Var(&traceSampleRatio, "trace-sample-ratio", 1,
+		"The share of reconciliations traced, from 0 to 1.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -74,6 +139,62 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
+	gates, err := config.ParseFeatureGates(featureGates)
+	if err != nil {
+		setupLog.Error(err, "invalid --feature-gates")
+		os.Exit(1)
+	}
+	set := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	store, err := config.NewStore(configFile, func(c *config.ControllerConfig) {
+		if set["max-concurrent-reconciles"] {
+			c.MaxConcurrentReconciles = maxConcurrentReconciles
+		}
+		if set["kube-api-qps"] {
+			c.Client.QPS = float32(kubeAPIQPS)
+		}
+		if set["kube-api-burst"] {
+			c.Client.Burst = kubeAPIBurst
+		}
+		if set["sync-period"] {
+			c.SyncPeriod = &metav1.Duration{Duration: syncPeriod}
+		}
+		if set["watch-namespaces"] {
+			c.Namespaces = nil
+			for _, ns := range strings.Split(watchNamespaces, ",") {
+				if ns = strings.TrimSpace(ns); ns != "" {
+					c.Namespaces = append(c.Namespaces, ns)
+				}
+			}
+		}
+		if set["namespace-selector"] {
+			c.NamespaceSelector = namespaceSelector
+		}
+		for name, enabled := range gates {
+			c.FeatureGates[name] = enabled
+		}
+	})
+	if err != nil {
+		setupLog.Error(err, "unable to load the controller configuration")
+		os.Exit(1)
+	}
+	controllerConfig := store.Get()
+
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Options{
+		Endpoint:    otlpEndpoint,
+		SampleRatio: traceSampleRatio,
+		ServiceName: "custom-controller",
+	})
+	if err != nil {
+		setupLog.Error(err, "unable to set up tracing")
+		os.Exit(1)
+	}
+
+	if enableSharding && (!enableLeaderElection || shardName == "" || shardLeaseNamespace == "") {
+		setupLog.Error(nil, "--sharding requires --leader-elect, --shard-name and --shard-lease-namespace")
+		os.Exit(1)
+	}
+
 	// if the enable-http2 flag is false (the default), http/2 should be disabled
 	// due to its vulnerabilities. More specifically, disabling http/2 will
 	// prevent from being vulnerable to the HTTP/2 Stream Cancelation and
@@ -90,21 +211,53 @@ func main() {
 		tlsOpts = append(tlsOpts, disableHTTP2)
 	}
 
+	webhookCertDir := filepath.Join(os.TempDir(), "k8s-webhook-server", "serving-certs")
 	webhookServer := webhook.NewServer(webhook.Options{
 		TLSOpts: tlsOpts,
+		CertDir: webhookCertDir,
 	})
 
-	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
+	cfg := ctrl.GetConfigOrDie()
+	if controllerConfig.Client.QPS > 0 {
+		cfg.QPS = controllerConfig.Client.QPS
+	}
+	if controllerConfig.Client.Burst > 0 {
+		cfg.Burst = controllerConfig.Client.Burst
+	}
+	namespaces, err := resolveNamespaces(cfg, controllerConfig)
+	if err != nil {
+		setupLog.Error(err, "unable to find the namespaces to watch")
+		os.Exit(1)
+	}
+	cacheOptions := cache.Options{SyncPeriod: &controllerConfig.SyncPeriod.Duration}
+	if len(namespaces) > 0 {
+		setupLog.Info("watching namespaces", "namespaces", namespaces)
+		cacheOptions.DefaultNamespaces = map[string]cache.Config{}
+		for _, ns := range namespaces {
+			cacheOptions.DefaultNamespaces[ns] = cache.Config{}
+		}
+	}
+	if enableSharding {
+		setupLog.Info("sharding enabled", "shard", shardName)
+		cacheOptions.ByObject = map[client.Object]cache.ByObject{
+			&myv1alpha1.MyAppResource{}: {
+				Label: labels.SelectorFromSet(labels.Set{sharding.ShardLabel: shardName}),
+			},
+		}
+	}
+	mgr, err := ctrl.NewManager(cfg, ctrl.Options{
 		Scheme: scheme,
+		Cache:  cacheOptions,
 		Metrics: metricsserver.Options{
 			BindAddress:   metricsAddr,
 			SecureServing: secureMetrics,
 			TLSOpts:       tlsOpts,
 		},
-		WebhookServer:          webhookServer,
-		HealthProbeBindAddress: probeAddr,
-		LeaderElection:         enableLeaderElection,
-		LeaderElectionID:       "a921a039.api.group",
+		WebhookServer:           webhookServer,
+		HealthProbeBindAddress:  probeAddr,
+		LeaderElection:          enableLeaderElection,
+		LeaderElectionID:        leaderElectionID,
+		LeaderElectionNamespace: leaderElectionNamespace,
 		// LeaderElectionReleaseOnCancel defines if the leader should step down voluntarily
 		// when the Manager ends. This requires the binary to immediately end when the
 		// Manager is stopped, otherwise, this setting is unsafe. Setting this significantly
@@ -122,27 +275,168 @@ func main() {
 		os.Exit(1)
 	}
 
+	renderOptions, err := detectRenderOptions(cfg)
+	if err != nil {
+		setupLog.Error(err, "unable to detect the Kubernetes version")
+		os.Exit(1)
+	}
+	setupLog.Info("detected cluster features", "nativeSidecars", renderOptions.NativeSidecars)
+
+	if err := mgr.Add(store); err != nil {
+		setupLog.Error(err, "unable to watch the controller configuration")
+		os.Exit(1)
+	}
+	if err := mgr.Add(config.NewNamespaceWatcher(mgr.GetAPIReader(), controllerConfig, namespaces)); err != nil {
+		setupLog.Error(err, "unable to watch the namespace selector")
+		os.Exit(1)
+	}
+	if enableSharding {
+		if err := mgr.Add(&sharding.Shard{
+			Client:    mgr.GetClient(),
+			Reader:    mgr.GetAPIReader(),
+			Name:      shardName,
+			Namespace: shardLeaseNamespace,
+		}); err != nil {
+			setupLog.Error(err, "unable to announce the shard")
+			os.Exit(1)
+		}
+		coordinatorCache, err := sharding.NewCache(mgr.GetConfig(), mgr.GetScheme(), mgr.GetRESTMapper(),
+			namespaces, shardLeaseNamespace)
+		if err != nil {
+			setupLog.Error(err, "unable to create the cache of the shard coordinator")
+			os.Exit(1)
+		}
+		if err := mgr.Add(&sharding.Coordinator{
+			Client:    mgr.GetClient(),
+			Cache:     coordinatorCache,
+			Namespace: shardLeaseNamespace,
+		}); err != nil {
+			setupLog.Error(err, "unable to create the shard coordinator")
+			os.Exit(1)
+		}
+	} else {
+		shardName = ""
+	}
 	if err = (&controller.MyAppResourceReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:                  tracing.NewClient(mgr.GetClient()),
+		Scheme:                  mgr.GetScheme(),
+		RenderOptions:           renderOptions,
+		Config:                  store,
+		Namespaces:              namespaces,
+		Shard:                   shardName,
+		Recorder:                mgr.GetEventRecorderFor("myappresource-controller"),
+		MaxConcurrentReconciles: controllerConfig.MaxConcurrentReconciles,
+		RateLimiter:             controllerConfig.NewRateLimiter(),
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "MyAppResource")
 		os.Exit(1)
 	}
+	enableWebhooks := os.Getenv("ENABLE_WEBHOOKS") != "false"
+	if enableWebhooks {
+		if err = webhookv1alpha1.SetupMyAppResourceWebhookWithManager(mgr, renderOptions, store); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "MyAppResource")
+			os.Exit(1)
+		}
+	}
 	//+kubebuilder:scaffold:builder
 
-	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
-		setupLog.Error(err, "unable to set up health check")
-		os.Exit(1)
+	healthzChecks := map[string]healthz.Checker{
+		"ping": healthz.Ping,
 	}
-	if err := mgr.AddReadyzCheck("readyz", healthz.Ping); err != nil {
-		setupLog.Error(err, "unable to set up ready check")
+	readyzChecks := map[string]healthz.Checker{
+		"informer-sync": health.CacheSync(mgr.GetCache()),
+	}
+	dc, err := discovery.NewDiscoveryClientForConfig(cfg)
+	if err != nil {
+		setupLog.Error(err, "unable to create the API server check")
 		os.Exit(1)
 	}
+	readyzChecks["apiserver"] = health.APIServer(dc.RESTClient())
+	if enableWebhooks {
+		readyzChecks["webhook-server"] = mgr.GetWebhookServer().StartedChecker()
+		readyzChecks["webhook-cert"] = health.WebhookCert(webhookCertDir, "tls.crt")
+	}
+	if enableLeaderElection {
+		hostname, err := os.Hostname()
+		if err != nil {
+			setupLog.Error(err, "unable to create the leader election check")
+			os.Exit(1)
+		}
+		if leaderElectionNamespace == "" {
+			leaderElectionNamespace, err = inClusterNamespace()
+			if err != nil {
+				setupLog.Error(err, "unable to create the leader election check")
+				os.Exit(1)
+			}
+		}
+		readyzChecks["leader-election"] = (&health.LeaderElection{
+			Reader:    mgr.GetAPIReader(),
+			Elected:   mgr.Elected(),
+			Namespace: leaderElectionNamespace,
+			Name:      leaderElectionID,
+			Identity:  hostname,
+		}).Check
+	}
+	for name, check := range healthzChecks {
+		if err := mgr.AddHealthzCheck(name, check); err != nil {
+			setupLog.Error(err, "unable to set up health check", "check", name)
+			os.Exit(1)
+		}
+	}
+	for name, check := range readyzChecks {
+		if err := mgr.AddReadyzCheck(name, check); err != nil {
+			setupLog.Error(err, "unable to set up ready check", "check", name)
+			os.Exit(1)
+		}
+	}
 
 	setupLog.Info("starting manager")
-	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
+	err = mgr.Start(ctrl.SetupSignalHandler())
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	if err := shutdownTracing(ctx); err != nil {
+		setupLog.Error(err, "unable to flush the traces")
+	}
+	cancel()
+	if err != nil {
 		setupLog.Error(err, "problem running manager")
 		os.Exit(1)
 	}
 }
+
+// resolveNamespaces returns the namespaces the manager watches, or nil for
+// all of them. Namespaces matching the selector are listed before the
+// manager, and its cache, exist.
+func resolveNamespaces(cfg *rest.Config, controllerConfig config.ControllerConfig) ([]string, error) {
+	if controllerConfig.NamespaceSelector == "" {
+		return config.ResolveNamespaces(context.Background(), nil, controllerConfig)
+	}
+	c, err := client.New(cfg, client.Options{Scheme: scheme})
+	if err != nil {
+		return nil, err
+	}
+	return config.ResolveNamespaces(context.Background(), c, controllerConfig)
+}
+
+// inClusterNamespace returns the namespace of the service account of the
+// manager, where the manager puts the leader election Lease by default.
+func inClusterNamespace() (string, error) {
+	data, err := os.ReadFile("/var/run/secrets/kubernetes.io/serviceaccount/namespace")
+	if err != nil {
+		return "", fmt.Errorf("unable to find the leader election namespace: %w", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// detectRenderOptions asks the API server for its version to find out which
+// pod features the render profiles may use.
+func detectRenderOptions(cfg *rest.Config) (render.Options, error) {
+	dc, err := discovery.NewDiscoveryClientForConfig(cfg)
+	if err != nil {
+		return render.Options{}, err
+	}
+	info, err := dc.ServerVersion()
+	if err != nil {
+		return render.Options{}, err
+	}
+	return render.OptionsForVersion(info)
+}
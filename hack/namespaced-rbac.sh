@@ -0,0 +1,93 @@
+#!/usr/bin/env bash
+# Writes the manifests restricting the manager to some namespaces into
+# config/namespaced: a Role and RoleBinding per namespace granting the
+# rules of config/rbac/role.yaml, a patch passing the namespaces to
+# --watch-namespaces, and a patch giving the ValidatingWebhookConfiguration
+# a name of its own, suffixed with INSTALL_NAME (the first namespace by
+# default), and limiting it to the namespaces.
+#
+# Usage: hack/namespaced-rbac.sh NAMESPACE[,NAMESPACE...]
+set -euo pipefail
+
+NAME_PREFIX=${NAME_PREFIX:-custom-controller-}
+SYSTEM_NAMESPACE=${SYSTEM_NAMESPACE:-custom-controller-system}
+ROOT=$(cd "$(dirname "$0")/.." && pwd)
+OUT=${ROOT}/config/namespaced
+
+if [ -z "${1:-}" ]; then
+	echo "usage: $0 NAMESPACE[,NAMESPACE...]" >&2
+	exit 1
+fi
+IFS=, read -r -a namespaces <<<"$1"
+INSTALL_NAME=${INSTALL_NAME:-${namespaces[0]}}
+
+# The rules of the ClusterRole generated by controller-gen.
+rules=$(sed -n '/^rules:/,$p' "${ROOT}/config/rbac/role.yaml")
+
+{
+	for ns in "${namespaces[@]}"; do
+		cat <<YAML
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: Role
+metadata:
+  name: ${NAME_PREFIX}manager-role
+  namespace: ${ns}
+${rules}
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: RoleBinding
+metadata:
+  name: ${NAME_PREFIX}manager-rolebinding
+  namespace: ${ns}
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: Role
+  name: ${NAME_PREFIX}manager-role
+subjects:
+- kind: ServiceAccount
+  name: ${NAME_PREFIX}controller-manager
+  namespace: ${SYSTEM_NAMESPACE}
+YAML
+	done
+} >"${OUT}/namespaced_rbac.yaml"
+
+# The arguments replace those set in config/default/manager_auth_proxy_patch.yaml.
+cat >"${OUT}/manager_namespaces_patch.yaml" <<YAML
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: controller-manager
+  namespace: system
+spec:
+  template:
+    spec:
+      containers:
+      - name: manager
+        args:
+        - "--health-probe-bind-address=:8081"
+        - "--metrics-bind-address=127.0.0.1:8080"
+        - "--leader-elect"
+        - "--config=/etc/manager/controller_config.yaml"
+        - "--watch-namespaces=$1"
+YAML
+
+# Other installations restricted to other namespaces have webhooks of their
+# own, which must neither replace this one nor validate its namespaces.
+{
+	cat <<YAML
+- op: replace
+  path: /metadata/name
+  value: ${NAME_PREFIX}validating-webhook-configuration-${INSTALL_NAME}
+- op: add
+  path: /webhooks/0/namespaceSelector
+  value:
+    matchExpressions:
+    - key: kubernetes.io/metadata.name
+      operator: In
+      values:
+YAML
+	for ns in "${namespaces[@]}"; do
+		echo "      - ${ns}"
+	done
+} >"${OUT}/webhook_namespaces_patch.yaml"
@@ -0,0 +1,324 @@
+/*
+Copyright 2024 shiliohstuart6.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package config loads the configuration file of the manager, and reloads
+// the settings that can change while it runs.
+package config
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/time/rate"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/ratelimiter"
+	"sigs.k8s.io/yaml"
+
+	myv1alpha1 "github.com/shilohstuart6/Custom-Controller.git/api/v1alpha1"
+)
+
+const (
+	// APIVersion is the version of the configuration file format.
+	APIVersion = "config.my.api.group/v1alpha1"
+	// Kind is the kind of the configuration file.
+	Kind = "ControllerConfig"
+)
+
+// ControllerConfig is the configuration file of the manager. Only Defaults
+// and FeatureGates are reloaded while the manager runs; the other settings
+// take effect on restart.
+type ControllerConfig struct {
+	metav1.TypeMeta `json:",inline"`
+
+	// MaxConcurrentReconciles is the number of MyAppResources reconciled in
+	// parallel. Defaults to 1.
+	MaxConcurrentReconciles int `json:"maxConcurrentReconciles,omitempty"`
+
+	// RateLimiter paces the retries of failed reconciliations.
+	RateLimiter RateLimiter `json:"rateLimiter,omitempty"`
+
+	// Client limits the requests the manager sends to the API server.
+	Client Client `json:"client,omitempty"`
+
+	// SyncPeriod is how often every watched object is reconciled again.
+	// Defaults to 10h.
+	SyncPeriod *metav1.Duration `json:"syncPeriod,omitempty"`
+
+	// Namespaces restricts the manager to these namespaces. All namespaces
+	// are watched when both Namespaces and NamespaceSelector are empty.
+	Namespaces []string `json:"namespaces,omitempty"`
+
+	// NamespaceSelector is a label selector adding the namespaces it
+	// matches to Namespaces. It is evaluated at startup; the manager exits
+	// to be restarted when the matching namespaces change.
+	NamespaceSelector string `json:"namespaceSelector,omitempty"`
+
+	// Defaults fill the settings a MyAppResource leaves empty.
+	Defaults Defaults `json:"defaults,omitempty"`
+
+	// FeatureGates turn features on or off by name.
+	FeatureGates map[string]bool `json:"featureGates,omitempty"`
+}
+
+// RateLimiter configures the queue of the controller. Failed items are
+// retried after a delay growing exponentially from BaseDelay to MaxDelay,
+// and the whole queue is limited to QPS items per second with bursts of
+// Burst.
+type RateLimiter struct {
+	// Defaults to 5ms.
+	BaseDelay *metav1.Duration `json:"baseDelay,omitempty"`
+	// Defaults to 1000s.
+	MaxDelay *metav1.Duration `json:"maxDelay,omitempty"`
+	// Defaults to 10.
+	QPS float64 `json:"qps,omitempty"`
+	// Defaults to 100.
+	Burst int `json:"burst,omitempty"`
+}
+
+// Client limits the requests to the API server. Zero values keep the
+// client-go defaults.
+type Client struct {
+	QPS   float32 `json:"qps,omitempty"`
+	Burst int     `json:"burst,omitempty"`
+}
+
+// Defaults fill the settings a MyAppResource leaves empty.
+type Defaults struct {
+	Resources myv1alpha1.RequestsAndLimits `json:"resources,omitempty"`
+}
+
+// FeatureNativeSidecars lets sidecars run as native sidecars on clusters
+// supporting them. Turning it off keeps them in the containers of the pod.
+const FeatureNativeSidecars = "NativeSidecars"
+
+// knownFeatures are the feature gates and their default values.
+var knownFeatures = map[string]bool{
+	FeatureNativeSidecars: true,
+}
+
+// DefaultConfig returns the configuration used without a configuration file.
+func DefaultConfig() ControllerConfig {
+	c := ControllerConfig{TypeMeta: metav1.TypeMeta{APIVersion: APIVersion, Kind: Kind}}
+	c.SetDefaults()
+	return c
+}
+
+// SetDefaults fills the settings left empty.
+func (c *ControllerConfig) SetDefaults() {
+	if c.MaxConcurrentReconciles == 0 {
+		c.MaxConcurrentReconciles = 1
+	}
+	if c.SyncPeriod == nil {
+		c.SyncPeriod = &metav1.Duration{Duration: 10 * time.Hour}
+	}
+	rl := &c.RateLimiter
+	if rl.BaseDelay == nil {
+		rl.BaseDelay = &metav1.Duration{Duration: 5 * time.Millisecond}
+	}
+	if rl.MaxDelay == nil {
+		rl.MaxDelay = &metav1.Duration{Duration: 1000 * time.Second}
+	}
+	if rl.QPS == 0 {
+		rl.QPS = 10
+	}
+	if rl.Burst == 0 {
+		rl.Burst = 100
+	}
+	gates := map[string]bool{}
+	for name, enabled := range knownFeatures {
+		gates[name] = enabled
+	}
+	for name, enabled := range c.FeatureGates {
+		gates[name] = enabled
+	}
+	c.FeatureGates = gates
+}
+
+// Validate reports the problems with a defaulted configuration.
+func (c *ControllerConfig) Validate() field.ErrorList {
+	var errs field.ErrorList
+
+	if c.APIVersion != APIVersion {
+		errs = append(errs, field.NotSupported(field.NewPath("apiVersion"), c.APIVersion, []string{APIVersion}))
+	}
+	if c.Kind != Kind {
+		errs = append(errs, field.NotSupported(field.NewPath("kind"), c.Kind, []string{Kind}))
+	}
+	if c.MaxConcurrentReconciles < 1 {
+		errs = append(errs, field.Invalid(field.NewPath("maxConcurrentReconciles"), c.MaxConcurrentReconciles, "must be at least 1"))
+	}
+
+	rlPath := field.NewPath("rateLimiter")
+	rl := c.RateLimiter
+	if rl.BaseDelay.Duration <= 0 {
+		errs = append(errs, field.Invalid(rlPath.Child("baseDelay"), rl.BaseDelay.Duration.String(), "must be positive"))
+	}
+	if rl.MaxDelay.Duration < rl.BaseDelay.Duration {
+		errs = append(errs, field.Invalid(rlPath.Child("maxDelay"), rl.MaxDelay.Duration.String(), "must not be less than baseDelay"))
+	}
+	if rl.QPS < 0 {
+		errs = append(errs, field.Invalid(rlPath.Child("qps"), rl.QPS, "must not be negative"))
+	}
+	if rl.Burst < 0 {
+		errs = append(errs, field.Invalid(rlPath.Child("burst"), rl.Burst, "must not be negative"))
+	}
+
+	clientPath := field.NewPath("client")
+	if c.Client.QPS < 0 {
+		errs = append(errs, field.Invalid(clientPath.Child("qps"), c.Client.QPS, "must not be negative"))
+	}
+	if c.Client.Burst < 0 {
+		errs = append(errs, field.Invalid(clientPath.Child("burst"), c.Client.Burst, "must not be negative"))
+	}
+
+	if c.SyncPeriod.Duration <= 0 {
+		errs = append(errs, field.Invalid(field.NewPath("syncPeriod"), c.SyncPeriod.Duration.String(), "must be positive"))
+	}
+
+	nsPath := field.NewPath("namespaces")
+	seen := map[string]bool{}
+	for i, ns := range c.Namespaces {
+		for _, msg := range validation.IsDNS1123Label(ns) {
+			errs = append(errs, field.Invalid(nsPath.Index(i), ns, msg))
+		}
+		if seen[ns] {
+			errs = append(errs, field.Duplicate(nsPath.Index(i), ns))
+		}
+		seen[ns] = true
+	}
+
+	if _, err := labels.Parse(c.NamespaceSelector); err != nil {
+		errs = append(errs, field.Invalid(field.NewPath("namespaceSelector"), c.NamespaceSelector, err.Error()))
+	}
+
+	resourcesPath := field.NewPath("defaults", "resources")
+	r := c.Defaults.Resources
+	for _, q := range []struct {
+		name  string
+		value string
+	}{
+		{"memoryRequest", r.MemoryRequest},
+		{"memoryLimit", r.MemoryLimit},
+		{"cpuRequest", r.CpuRequest},
+		{"cpuLimit", r.CpuLimit},
+	} {
+		if q.value == "" {
+			continue
+		}
+		if _, err := resource.ParseQuantity(q.value); err != nil {
+			errs = append(errs, field.Invalid(resourcesPath.Child(q.name), q.value, err.Error()))
+		}
+	}
+
+	var known []string
+	for name := range knownFeatures {
+		known = append(known, name)
+	}
+	sort.Strings(known)
+	var gates []string
+	for name := range c.FeatureGates {
+		gates = append(gates, name)
+	}
+	sort.Strings(gates)
+	for _, name := range gates {
+		if _, ok := knownFeatures[name]; !ok {
+			errs = append(errs, field.NotSupported(field.NewPath("featureGates").Key(name), name, known))
+		}
+	}
+
+	return errs
+}
+
+// NamespaceScoped reports whether the manager is restricted to some
+// namespaces.
+func (c *ControllerConfig) NamespaceScoped() bool {
+	return len(c.Namespaces) > 0 || c.NamespaceSelector != ""
+}
+
+// Enabled reports whether the feature gate is on.
+func (c *ControllerConfig) Enabled(feature string) bool {
+	if enabled, ok := c.FeatureGates[feature]; ok {
+		return enabled
+	}
+	return knownFeatures[feature]
+}
+
+// Parse decodes, defaults and validates a configuration file. Unknown
+// fields are rejected, and apiVersion and kind must be set.
+func Parse(data []byte) (ControllerConfig, error) {
+	var c ControllerConfig
+	if err := yaml.UnmarshalStrict(data, &c); err != nil {
+		return ControllerConfig{}, err
+	}
+	c.SetDefaults()
+	if errs := c.Validate(); len(errs) > 0 {
+		return ControllerConfig{}, errs.ToAggregate()
+	}
+	return c, nil
+}
+
+// Load reads the configuration file at path.
+func Load(path string) (ControllerConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ControllerConfig{}, err
+	}
+	c, err := Parse(data)
+	if err != nil {
+		return ControllerConfig{}, fmt.Errorf("loading %s: %w", path, err)
+	}
+	return c, nil
+}
+
+// NewRateLimiter returns the rate limiter of the controller queue.
+func (c *ControllerConfig) NewRateLimiter() ratelimiter.RateLimiter {
+	rl := c.RateLimiter
+	return workqueue.NewMaxOfRateLimiter(
+		workqueue.NewItemExponentialFailureRateLimiter(rl.BaseDelay.Duration, rl.MaxDelay.Duration),
+		&workqueue.BucketRateLimiter{Limiter: rate.NewLimiter(rate.Limit(rl.QPS), rl.Burst)},
+	)
+}
+
+// ParseFeatureGates parses a comma-separated list of name=bool pairs, as
+// given to the --feature-gates flag.
+func ParseFeatureGates(s string) (map[string]bool, error) {
+	gates := map[string]bool{}
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("feature gate %q is not of the form name=true|false", pair)
+		}
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("feature gate %q: %w", name, err)
+		}
+		gates[name] = enabled
+	}
+	return gates, nil
+}
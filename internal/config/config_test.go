@@ -0,0 +1,186 @@
+/*
+Copyright 2024 shiliohstuart6.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/shilohstuart6/Custom-Controller.git/pkg/render"
+)
+
+var _ = Describe("ControllerConfig", func() {
+	It("Should load the configuration shipped with the manager", func() {
+		c, err := Load(filepath.Join("..", "..", "config", "manager", "controller_config.yaml"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(c.MaxConcurrentReconciles).To(Equal(1))
+		Expect(c.SyncPeriod.Duration).To(Equal(10 * time.Hour))
+		Expect(c.Defaults.Resources.MemoryLimit).To(Equal("64Mi"))
+		Expect(c.Enabled(FeatureNativeSidecars)).To(BeTrue())
+	})
+
+	It("Should default the settings left empty", func() {
+		c, err := Parse([]byte("apiVersion: config.my.api.group/v1alpha1\nkind: ControllerConfig\n"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(c).To(Equal(DefaultConfig()))
+		Expect(c.RateLimiter.MaxDelay.Duration).To(Equal(1000 * time.Second))
+	})
+
+	It("Should reject unknown versions, fields and invalid values", func() {
+		_, err := Parse([]byte("apiVersion: config.my.api.group/v2\nkind: ControllerConfig\n"))
+		Expect(err).To(MatchError(ContainSubstring("apiVersion: Unsupported value")))
+
+		_, err = Parse([]byte("apiVersion: config.my.api.group/v1alpha1\nkind: ControllerConfig\nworkers: 4\n"))
+		Expect(err).To(MatchError(ContainSubstring(`unknown field "workers"`)))
+
+		_, err = Parse([]byte(`apiVersion: config.my.api.group/v1alpha1
+kind: ControllerConfig
+maxConcurrentReconciles: -1
+rateLimiter:
+  baseDelay: 1m
+  maxDelay: 1s
+namespaces: [team-a, team-a]
+namespaceSelector: "tenant in (a"
+defaults:
+  resources:
+    cpuLimit: fast
+featureGates:
+  Teleport: true
+`))
+		Expect(err).To(MatchError(And(
+			ContainSubstring("maxConcurrentReconciles"),
+			ContainSubstring("rateLimiter.maxDelay"),
+			ContainSubstring("namespaces[1]: Duplicate value"),
+			ContainSubstring("namespaceSelector"),
+			ContainSubstring("defaults.resources.cpuLimit"),
+			ContainSubstring("featureGates[Teleport]"),
+		)))
+	})
+
+	It("Should parse feature gates given as flags", func() {
+		gates, err := ParseFeatureGates("NativeSidecars=false, Other=true")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(gates).To(Equal(map[string]bool{"NativeSidecars": false, "Other": true}))
+
+		_, err = ParseFeatureGates("NativeSidecars")
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("Store", func() {
+	var path string
+
+	write := func(content string) {
+		Expect(os.WriteFile(path, []byte("apiVersion: config.my.api.group/v1alpha1\nkind: ControllerConfig\n"+content), 0o600)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		path = filepath.Join(GinkgoT().TempDir(), "controller_config.yaml")
+	})
+
+	It("Should keep flags ahead of the file", func() {
+		write("maxConcurrentReconciles: 2\n")
+		s, err := NewStore(path, func(c *ControllerConfig) {
+			c.MaxConcurrentReconciles = 8
+			c.FeatureGates[FeatureNativeSidecars] = false
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(s.Get().MaxConcurrentReconciles).To(Equal(8))
+		Expect(s.RenderOptions(render.Options{NativeSidecars: true}).NativeSidecars).To(BeFalse())
+	})
+
+	It("Should reload the defaults and feature gates only", func() {
+		write("maxConcurrentReconciles: 2\n")
+		s, err := NewStore(path, nil)
+		Expect(err).NotTo(HaveOccurred())
+		changes := 0
+		s.OnChange(func() { changes++ })
+
+		write("maxConcurrentReconciles: 4\ndefaults:\n  resources:\n    cpuLimit: 500m\nfeatureGates:\n  NativeSidecars: false\n")
+		s.reload()
+		Expect(changes).To(Equal(1))
+		Expect(s.Get().MaxConcurrentReconciles).To(Equal(2))
+		opts := s.RenderOptions(render.Options{NativeSidecars: true})
+		Expect(opts.NativeSidecars).To(BeFalse())
+		Expect(opts.DefaultResources.CpuLimit).To(Equal("500m"))
+
+		By("Ignoring an invalid file")
+		write("defaults:\n  resources:\n    cpuLimit: fast\n")
+		s.reload()
+		Expect(s.Get().Defaults.Resources.CpuLimit).To(Equal("500m"))
+
+		By("Not notifying changes that need a restart")
+		write("maxConcurrentReconciles: 8\ndefaults:\n  resources:\n    cpuLimit: 500m\nfeatureGates:\n  NativeSidecars: false\n")
+		s.reload()
+		Expect(changes).To(Equal(1))
+	})
+})
+
+var _ = Describe("Namespaces", func() {
+	ctx := context.Background()
+
+	namespace := func(name string, labels map[string]string) *corev1.Namespace {
+		return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+	}
+
+	var reader client.Reader
+
+	BeforeEach(func() {
+		reader = fake.NewClientBuilder().WithObjects(
+			namespace("team-a", map[string]string{"tenant": "a"}),
+			namespace("team-b", map[string]string{"tenant": "a"}),
+			namespace("team-c", map[string]string{"tenant": "c"}),
+		).Build()
+	})
+
+	It("Should watch all namespaces unless restricted", func() {
+		Expect(ResolveNamespaces(ctx, reader, DefaultConfig())).To(BeNil())
+	})
+
+	It("Should add the namespaces matching the selector to the configured ones", func() {
+		c := DefaultConfig()
+		c.Namespaces = []string{"team-c", "team-a"}
+		c.NamespaceSelector = "tenant=a"
+		Expect(ResolveNamespaces(ctx, reader, c)).To(Equal([]string{"team-a", "team-b", "team-c"}))
+	})
+
+	It("Should refuse a selector matching no namespace", func() {
+		c := DefaultConfig()
+		c.NamespaceSelector = "tenant=z"
+		_, err := ResolveNamespaces(ctx, reader, c)
+		Expect(err).To(MatchError(ContainSubstring("no namespace matches")))
+	})
+
+	It("Should notice namespaces being selected", func() {
+		c := DefaultConfig()
+		c.NamespaceSelector = "tenant=a"
+		w := NewNamespaceWatcher(reader, c, []string{"team-a", "team-b"})
+		Expect(w.changed(ctx)).To(BeFalse())
+
+		Expect(reader.(client.Client).Create(ctx, namespace("team-d", map[string]string{"tenant": "a"}))).To(Succeed())
+		Expect(w.changed(ctx)).To(BeTrue())
+	})
+})
@@ -0,0 +1,118 @@
+/*
+Copyright 2024 shiliohstuart6.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// namespaceResyncInterval is how often the namespaces matching the
+// namespace selector are listed again.
+const namespaceResyncInterval = time.Minute
+
+// ResolveNamespaces returns the sorted namespaces the manager watches: the
+// configured ones and those matching the namespace selector. It returns nil
+// when the manager watches all namespaces, and an error when a selector
+// leaves it with none, as an empty list would widen it to all of them.
+func ResolveNamespaces(ctx context.Context, c client.Reader, cfg ControllerConfig) ([]string, error) {
+	if !cfg.NamespaceScoped() {
+		return nil, nil
+	}
+	namespaces := sets.New(cfg.Namespaces...)
+	if cfg.NamespaceSelector != "" {
+		selector, err := labels.Parse(cfg.NamespaceSelector)
+		if err != nil {
+			return nil, err
+		}
+		list := corev1.NamespaceList{}
+		if err := c.List(ctx, &list, client.MatchingLabelsSelector{Selector: selector}); err != nil {
+			return nil, fmt.Errorf("listing the namespaces matching %q: %w", cfg.NamespaceSelector, err)
+		}
+		for _, ns := range list.Items {
+			namespaces.Insert(ns.Name)
+		}
+	}
+	if namespaces.Len() == 0 {
+		return nil, fmt.Errorf("no namespace matches the namespace selector %q", cfg.NamespaceSelector)
+	}
+	return sets.List(namespaces), nil
+}
+
+// NamespaceWatcher stops the manager when the namespaces matching the
+// namespace selector change. The cache of the manager watches a fixed set
+// of namespaces, so the manager has to be restarted to pick up the new set.
+type NamespaceWatcher struct {
+	reader     client.Reader
+	config     ControllerConfig
+	namespaces []string
+}
+
+// NewNamespaceWatcher returns a NamespaceWatcher for the namespaces resolved
+// from cfg at startup. reader should not be backed by the cache of the
+// manager, which does not watch namespaces.
+func NewNamespaceWatcher(reader client.Reader, cfg ControllerConfig, namespaces []string) *NamespaceWatcher {
+	return &NamespaceWatcher{reader: reader, config: cfg, namespaces: namespaces}
+}
+
+// Start lists the matching namespaces until the context is done, and
+// returns an error, which stops the manager, when they differ from the
+// namespaces watched.
+func (w *NamespaceWatcher) Start(ctx context.Context) error {
+	if w.config.NamespaceSelector == "" {
+		<-ctx.Done()
+		return nil
+	}
+	ticker := time.NewTicker(namespaceResyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			changed, err := w.changed(ctx)
+			if err != nil {
+				configlog.Error(err, "Failed to list the watched namespaces")
+				continue
+			}
+			if changed {
+				return fmt.Errorf("the namespaces matching %q changed, restarting to watch them", w.config.NamespaceSelector)
+			}
+		}
+	}
+}
+
+// NeedLeaderElection lets every replica watch the namespaces, as each has
+// its own cache.
+func (w *NamespaceWatcher) NeedLeaderElection() bool {
+	return false
+}
+
+func (w *NamespaceWatcher) changed(ctx context.Context) (bool, error) {
+	namespaces, err := ResolveNamespaces(ctx, w.reader, w.config)
+	if err != nil {
+		return false, err
+	}
+	return !slices.Equal(namespaces, w.namespaces), nil
+}
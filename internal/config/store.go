@@ -0,0 +1,184 @@
+/*
+Copyright 2024 shiliohstuart6.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/shilohstuart6/Custom-Controller.git/pkg/render"
+)
+
+// reloadInterval is how often the configuration file is read again. Files
+// mounted from ConfigMaps are replaced rather than written to, which
+// polling copes with.
+const reloadInterval = 10 * time.Second
+
+var configlog = logf.Log.WithName("config")
+
+// Store holds the configuration of the running manager. Added to the
+// manager, it reloads the defaults and feature gates when the configuration
+// file changes.
+type Store struct {
+	path      string
+	overrides func(*ControllerConfig)
+
+	mu        sync.RWMutex
+	current   ControllerConfig
+	data      []byte
+	listeners []func()
+}
+
+// NewStore returns a Store holding the configuration loaded from path, or
+// the default configuration if path is empty. overrides, if not nil, is
+// applied to every version loaded, so flags keep precedence over the file.
+func NewStore(path string, overrides func(*ControllerConfig)) (*Store, error) {
+	s := &Store{path: path, overrides: overrides, current: DefaultConfig()}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		c, err := s.parse(data)
+		if err != nil {
+			return nil, err
+		}
+		s.current, s.data = c, data
+	} else if overrides != nil {
+		overrides(&s.current)
+		if errs := s.current.Validate(); len(errs) > 0 {
+			return nil, errs.ToAggregate()
+		}
+	}
+	return s, nil
+}
+
+func (s *Store) parse(data []byte) (ControllerConfig, error) {
+	c, err := Parse(data)
+	if err != nil {
+		return ControllerConfig{}, err
+	}
+	if s.overrides != nil {
+		s.overrides(&c)
+		if errs := c.Validate(); len(errs) > 0 {
+			return ControllerConfig{}, errs.ToAggregate()
+		}
+	}
+	return c, nil
+}
+
+// Get returns the current configuration. It must not be modified.
+func (s *Store) Get() ControllerConfig {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.current
+}
+
+// RenderOptions returns the options of the cluster with the current defaults
+// and feature gates applied.
+func (s *Store) RenderOptions(cluster render.Options) render.Options {
+	c := s.Get()
+	opts := cluster
+	opts.NativeSidecars = cluster.NativeSidecars && c.Enabled(FeatureNativeSidecars)
+	opts.DefaultResources = c.Defaults.Resources
+	return opts
+}
+
+// OnChange registers f to be called after each reload changing the defaults
+// or the feature gates, for instance to render every MyAppResource again.
+// f must not block.
+func (s *Store) OnChange(f func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.listeners = append(s.listeners, f)
+}
+
+// Start reloads the configuration file until the context is done.
+func (s *Store) Start(ctx context.Context) error {
+	if s.path == "" {
+		<-ctx.Done()
+		return nil
+	}
+	ticker := time.NewTicker(reloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			s.reload()
+		}
+	}
+}
+
+// NeedLeaderElection lets every replica reload its configuration, as the
+// webhook uses it too.
+func (s *Store) NeedLeaderElection() bool {
+	return false
+}
+
+// reload reads the configuration file again. An invalid file is reported and
+// ignored. Only the defaults and feature gates are taken from a valid one;
+// changes to the other settings are reported as needing a restart.
+func (s *Store) reload() {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		configlog.Error(err, "Failed to read the configuration file", "Path", s.path)
+		return
+	}
+	s.mu.RLock()
+	unchanged := bytes.Equal(data, s.data)
+	s.mu.RUnlock()
+	if unchanged {
+		return
+	}
+
+	c, err := s.parse(data)
+	if err != nil {
+		configlog.Error(err, "Ignoring invalid configuration file", "Path", s.path)
+		s.mu.Lock()
+		s.data = data
+		s.mu.Unlock()
+		return
+	}
+
+	s.mu.Lock()
+	next := s.current
+	next.Defaults = c.Defaults
+	next.FeatureGates = c.FeatureGates
+	if !reflect.DeepEqual(next, c) {
+		configlog.Info("Configuration changes other than defaults and feature gates take effect after a restart",
+			"Path", s.path)
+	}
+	changed := !reflect.DeepEqual(next, s.current)
+	s.current, s.data = next, data
+	listeners := s.listeners
+	s.mu.Unlock()
+	configlog.Info("Reloaded the configuration file", "Path", s.path)
+
+	if changed {
+		for _, f := range listeners {
+			f()
+		}
+	}
+}
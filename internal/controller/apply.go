@@ -0,0 +1,290 @@
+/*
+Copyright 2024 shiliohstuart6.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	myv1alpha1 "github.com/shilohstuart6/Custom-Controller.git/api/v1alpha1"
+	"github.com/shilohstuart6/Custom-Controller.git/internal/tracing"
+)
+
+const (
+	// fieldManager owns the fields the controller applies.
+	fieldManager = "myappresource-controller"
+
+	// appliedConfigAnnotation is set on every child to the hash of the
+	// configuration last applied to it.
+	appliedConfigAnnotation = "my.api.group/applied-config"
+)
+
+// applyResult is what apply did to an object.
+type applyResult int
+
+const (
+	objectUnchanged applyResult = iota
+	objectCreated
+	objectUpdated
+)
+
+func (r applyResult) String() string {
+	switch r {
+	case objectCreated:
+		return "created"
+	case objectUpdated:
+		return "updated"
+	default:
+		return "unchanged"
+	}
+}
+
+// apply server-side applies a rendered object with the custom resource as
+// its controller. Only the fields set on the object are owned by the
+// controller; fields set by other actors, like annotations added by kubectl
+// or admission webhooks, are left alone. Nothing is sent when the same
+// configuration was applied last and the live object still holds every
+// field rendered.
+func (r *MyAppResourceReconciler) apply(ctx context.Context, mar *myv1alpha1.MyAppResource,
+	desired client.Object) (result applyResult, err error) {
+	l := log.FromContext(ctx)
+
+	gvk, err := apiutil.GVKForObject(desired, r.Scheme)
+	if err != nil {
+		l.Error(err, "Unknown rendered object", "Name", desired.GetName())
+		return objectUnchanged, err
+	}
+	desired.GetObjectKind().SetGroupVersionKind(gvk)
+	kind := gvk.Kind
+
+	ctx, span := tracing.Tracer().Start(ctx, "Apply "+kind, trace.WithAttributes(attribute.String("k8s.name", desired.GetName())))
+	defer func() {
+		span.SetAttributes(attribute.String("apply.result", result.String()))
+		tracing.End(span, err)
+	}()
+
+	obj, err := r.Scheme.New(gvk)
+	if err != nil {
+		return objectUnchanged, err
+	}
+	live := obj.(client.Object)
+	if err := r.Get(ctx, client.ObjectKeyFromObject(desired), live); err != nil {
+		if client.IgnoreNotFound(err) != nil {
+			l.Error(err, "Failed to check for existing "+kind, "Name", desired.GetName())
+			return objectUnchanged, err
+		}
+		live = nil
+	}
+	if live != nil && !metav1.IsControlledBy(live, mar) {
+		if err := checkOwnership(mar, kind, live); err != nil {
+			return objectUnchanged, err
+		}
+		l.Info("Adopting existing "+kind, "Name", live.GetName())
+		r.event(mar, corev1.EventTypeNormal, "Adopted", fmt.Sprintf("Adopted existing %s %s", kind, live.GetName()))
+	}
+
+	switch d := desired.(type) {
+	case *appsv1.Deployment:
+		hash, err := r.configHash(ctx, mar.Namespace, &d.Spec.Template.Spec)
+		if err != nil {
+			l.Error(err, "Failed to hash referenced ConfigMaps and Secrets")
+			return objectUnchanged, err
+		}
+		if hash != "" {
+			metav1.SetMetaDataAnnotation(&d.Spec.Template.ObjectMeta, configHashAnnotation, hash)
+		}
+		if live != nil {
+			// The selector cannot change. Deployments created before it
+			// was scoped to the instance keep selecting every instance.
+			d.Spec.Selector = live.(*appsv1.Deployment).Spec.Selector
+		}
+	case *corev1.PersistentVolumeClaim:
+		if live != nil {
+			keepClaimSpec(d, live.(*corev1.PersistentVolumeClaim))
+		}
+	}
+	if err := ctrl.SetControllerReference(mar, desired, r.Scheme); err != nil {
+		l.Error(err, "Failed to set controller reference", "Kind", kind)
+		return objectUnchanged, err
+	}
+
+	hash, err := appliedConfigHash(desired)
+	if err != nil {
+		return objectUnchanged, err
+	}
+	// The applied configuration must be the same, not only the fields it
+	// sets: a field removed from the rendered object is only removed from
+	// the live one by applying again.
+	sameConfig := live != nil && live.GetAnnotations()[appliedConfigAnnotation] == hash
+	if sameConfig {
+		unchanged, err := isApplied(desired, live)
+		if err != nil {
+			return objectUnchanged, err
+		}
+		if unchanged {
+			l.V(1).Info(kind+" unchanged", "Name", desired.GetName())
+			childWrites.WithLabelValues(kind, "skipped").Inc()
+			if d, ok := live.(*appsv1.Deployment); ok {
+				r.recordRollout(mar, d)
+			}
+			return objectUnchanged, nil
+		}
+		// The same configuration was applied before: the object was changed
+		// by someone else.
+		l.Info("Reverting changes made to "+kind, "Name", desired.GetName())
+		driftCorrections.WithLabelValues(kind).Inc()
+	}
+	annotations := desired.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[appliedConfigAnnotation] = hash
+	desired.SetAnnotations(annotations)
+
+	l.Info("Applying "+kind, "Name", desired.GetName())
+	if err := r.Patch(ctx, desired, client.Apply, client.FieldOwner(fieldManager), client.ForceOwnership); err != nil {
+		l.Error(err, "Failed to apply "+kind, "Name", desired.GetName())
+		return objectUnchanged, err
+	}
+	l.Info(kind+" applied", "Name", desired.GetName(), "Namespace", desired.GetNamespace())
+	childWrites.WithLabelValues(kind, "applied").Inc()
+
+	if live == nil {
+		r.event(mar, corev1.EventTypeNormal, "Created", fmt.Sprintf("Created %s %s", kind, desired.GetName()))
+		if _, ok := desired.(*appsv1.Deployment); ok {
+			r.startRollout(desired.GetUID())
+		}
+		return objectCreated, nil
+	}
+	r.event(mar, corev1.EventTypeNormal, "Updated", fmt.Sprintf("Updated %s %s", kind, desired.GetName()))
+	if d, ok := desired.(*appsv1.Deployment); ok {
+		r.recordDeploymentChanges(mar, live.(*appsv1.Deployment), d)
+	}
+	return objectUpdated, nil
+}
+
+// recordDeploymentChanges records the scaling and the rollout an update of a
+// Deployment started.
+func (r *MyAppResourceReconciler) recordDeploymentChanges(mar *myv1alpha1.MyAppResource, old, d *appsv1.Deployment) {
+	from, to := ptr.Deref(old.Spec.Replicas, 1), ptr.Deref(d.Spec.Replicas, 1)
+	if from != to {
+		r.event(mar, corev1.EventTypeNormal, "Scaled",
+			fmt.Sprintf("Scaled Deployment %s from %d to %d replicas", d.Name, from, to))
+	}
+	if !equality.Semantic.DeepEqual(old.Spec.Template, d.Spec.Template) {
+		r.event(mar, corev1.EventTypeNormal, "RolloutStarted",
+			fmt.Sprintf("Rolling out Deployment %s generation %d", d.Name, d.Generation))
+		r.startRollout(d.UID)
+	}
+}
+
+// keepClaimSpec carries the immutable parts of an existing claim over to
+// the rendered one, and never asks for less storage than the claim already
+// has, since claims cannot shrink.
+func keepClaimSpec(desired, live *corev1.PersistentVolumeClaim) {
+	desired.Spec.StorageClassName = live.Spec.StorageClassName
+	desired.Spec.AccessModes = live.Spec.AccessModes
+
+	current := live.Spec.Resources.Requests[corev1.ResourceStorage]
+	if size := desired.Spec.Resources.Requests[corev1.ResourceStorage]; size.Cmp(current) < 0 {
+		desired.Spec.Resources.Requests = corev1.ResourceList{corev1.ResourceStorage: current}
+	}
+}
+
+// isApplied reports whether every field set on the rendered object holds the
+// same value on the live one, so applying it would change nothing. Fields
+// only set on the live object, defaulted by the API server or set by others,
+// are ignored, as is the status.
+func isApplied(desired, live client.Object) (bool, error) {
+	d, err := runtime.DefaultUnstructuredConverter.ToUnstructured(desired)
+	if err != nil {
+		return false, err
+	}
+	l, err := runtime.DefaultUnstructuredConverter.ToUnstructured(live)
+	if err != nil {
+		return false, err
+	}
+	delete(d, "apiVersion")
+	delete(d, "kind")
+	delete(d, "status")
+	return isSubset(d, l), nil
+}
+
+// isSubset reports whether the unstructured value desired is contained in
+// live: maps may have extra keys in live, lists must have the same length,
+// and other values must be equal.
+func isSubset(desired, live interface{}) bool {
+	switch d := desired.(type) {
+	case nil:
+		return true
+	case map[string]interface{}:
+		l, ok := live.(map[string]interface{})
+		if !ok {
+			return len(d) == 0 && live == nil
+		}
+		for k, v := range d {
+			if !isSubset(v, l[k]) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		l, ok := live.([]interface{})
+		if !ok {
+			return len(d) == 0 && live == nil
+		}
+		if len(d) != len(l) {
+			return false
+		}
+		for i := range d {
+			if !isSubset(d[i], l[i]) {
+				return false
+			}
+		}
+		return true
+	default:
+		return reflect.DeepEqual(desired, live)
+	}
+}
+
+// appliedConfigHash returns the hash of the configuration the object would
+// apply.
+func appliedConfigHash(obj client.Object) (string, error) {
+	b, err := json.Marshal(obj)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
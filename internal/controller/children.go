@@ -0,0 +1,169 @@
+/*
+Copyright 2024 shiliohstuart6.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	myv1alpha1 "github.com/shilohstuart6/Custom-Controller.git/api/v1alpha1"
+	"github.com/shilohstuart6/Custom-Controller.git/pkg/render"
+)
+
+// prunedKinds are the kinds whose objects are deleted once the custom
+// resource stops rendering them. Claims are kept so data is never lost, even
+// when the naming fields rename them, and Deployments are handled by
+// pruneDeployments.
+var prunedKinds = []func() client.ObjectList{
+	func() client.ObjectList { return &corev1.ServiceAccountList{} },
+	func() client.ObjectList { return &rbacv1.RoleBindingList{} },
+	func() client.ObjectList { return &networkingv1.NetworkPolicyList{} },
+	func() client.ObjectList { return &corev1.ServiceList{} },
+}
+
+// prune deletes the objects of the pruned kinds that the custom resource
+// controls but no longer renders. It reports whether Deployments left
+// behind by a rename are still waiting for their replacement to roll out, in
+// which case it keeps the other objects too: the old pods still run as the
+// old ServiceAccount, under the old RoleBindings and NetworkPolicy.
+func (r *MyAppResourceReconciler) prune(ctx context.Context, mar *myv1alpha1.MyAppResource, rendered []client.Object) (bool, error) {
+	keep := map[schema.GroupVersionKind]map[string]bool{}
+	for _, obj := range rendered {
+		gvk, err := apiutil.GVKForObject(obj, r.Scheme)
+		if err != nil {
+			return false, err
+		}
+		if keep[gvk] == nil {
+			keep[gvk] = map[string]bool{}
+		}
+		keep[gvk][obj.GetName()] = true
+	}
+
+	waiting, err := r.pruneDeployments(ctx, mar, keep[appsv1.SchemeGroupVersion.WithKind("Deployment")])
+	if err != nil || waiting {
+		return waiting, err
+	}
+	for _, newList := range prunedKinds {
+		list := newList()
+		gvk, err := apiutil.GVKForObject(list, r.Scheme)
+		if err != nil {
+			return false, err
+		}
+		gvk.Kind = strings.TrimSuffix(gvk.Kind, "List")
+		if err := r.deleteOwnedExcept(ctx, mar, list, keep[gvk]); err != nil {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+// pruneDeployments deletes the Deployments the custom resource controls but
+// no longer renders, once the rendered ones have rolled out, so renaming them
+// does not take the application down. It reports whether it is waiting.
+func (r *MyAppResourceReconciler) pruneDeployments(ctx context.Context, mar *myv1alpha1.MyAppResource,
+	keep map[string]bool) (bool, error) {
+	l := log.FromContext(ctx)
+
+	list := &appsv1.DeploymentList{}
+	if err := r.List(ctx, list, client.InNamespace(mar.Namespace),
+		client.MatchingLabels{render.InstanceLabel: render.Instance(*mar)}); err != nil {
+		l.Error(err, "Failed to list owned Deployments")
+		return false, err
+	}
+	var stale []*appsv1.Deployment
+	rolledOut := 0
+	for i := range list.Items {
+		d := &list.Items[i]
+		switch {
+		case !metav1.IsControlledBy(d, mar):
+		case !keep[d.Name]:
+			stale = append(stale, d)
+		case deploymentRolledOut(d):
+			rolledOut++
+		}
+	}
+	if len(stale) == 0 {
+		return false, nil
+	}
+	// The rendered Deployments may not be in the cache yet, so those that
+	// are missing count as not rolled out.
+	if rolledOut < len(keep) {
+		l.Info("Waiting for the rollout before deleting renamed Deployments", "Count", len(stale))
+		return true, nil
+	}
+	for _, d := range stale {
+		l.Info("Deleting renamed Deployment", "Name", d.Name)
+		if err := r.Delete(ctx, d); client.IgnoreNotFound(err) != nil {
+			l.Error(err, "Failed to delete renamed Deployment", "Name", d.Name)
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+// deploymentRolledOut reports whether every replica of d runs its current
+// template and is available.
+func deploymentRolledOut(d *appsv1.Deployment) bool {
+	replicas := int32(1)
+	if d.Spec.Replicas != nil {
+		replicas = *d.Spec.Replicas
+	}
+	s := d.Status
+	return s.ObservedGeneration >= d.Generation &&
+		s.UpdatedReplicas == replicas && s.Replicas == replicas && s.AvailableReplicas == replicas
+}
+
+// deleteOwnedExcept lists the objects of the list's type that carry the
+// instance label of the custom resource, and deletes those it controls whose
+// name is not in keep.
+func (r *MyAppResourceReconciler) deleteOwnedExcept(ctx context.Context, mar *myv1alpha1.MyAppResource,
+	list client.ObjectList, keep map[string]bool) error {
+	l := log.FromContext(ctx)
+
+	if err := r.List(ctx, list, client.InNamespace(mar.Namespace),
+		client.MatchingLabels{render.InstanceLabel: render.Instance(*mar)}); err != nil {
+		l.Error(err, "Failed to list owned objects")
+		return err
+	}
+	items, err := meta.ExtractList(list)
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		obj, ok := item.(client.Object)
+		if !ok || keep[obj.GetName()] || !metav1.IsControlledBy(obj, mar) {
+			continue
+		}
+		l.Info("Deleting unused object", "Kind", item.GetObjectKind().GroupVersionKind().Kind, "Name", obj.GetName())
+		if err := r.Delete(ctx, obj); client.IgnoreNotFound(err) != nil {
+			l.Error(err, "Failed to delete unused object", "Name", obj.GetName())
+			return err
+		}
+	}
+	return nil
+}
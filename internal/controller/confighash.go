@@ -0,0 +1,212 @@
+/*
+Copyright 2024 shiliohstuart6.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	myv1alpha1 "github.com/shilohstuart6/Custom-Controller.git/api/v1alpha1"
+	"github.com/shilohstuart6/Custom-Controller.git/pkg/render"
+)
+
+const (
+	// configMapIndexKey and secretIndexKey index MyAppResources by the names
+	// of the ConfigMaps and Secrets their pods consume.
+	configMapIndexKey = ".spec.configMapRefs"
+	secretIndexKey    = ".spec.secretRefs"
+
+	// configHashAnnotation is set on the pod template to the hash of every
+	// referenced ConfigMap and Secret, so that changing their data rolls the
+	// pods.
+	configHashAnnotation = "my.api.group/config-hash"
+)
+
+// referencedObjects returns the sorted names of the ConfigMaps and Secrets
+// consumed by the containers and volumes of the pod spec.
+func referencedObjects(spec *corev1.PodSpec) (configMaps, secrets []string) {
+	cms, secs := map[string]bool{}, map[string]bool{}
+
+	containers := append(append([]corev1.Container{}, spec.InitContainers...), spec.Containers...)
+	for _, c := range containers {
+		for _, env := range c.Env {
+			if env.ValueFrom == nil {
+				continue
+			}
+			if ref := env.ValueFrom.ConfigMapKeyRef; ref != nil {
+				cms[ref.Name] = true
+			}
+			if ref := env.ValueFrom.SecretKeyRef; ref != nil {
+				secs[ref.Name] = true
+			}
+		}
+		for _, from := range c.EnvFrom {
+			if from.ConfigMapRef != nil {
+				cms[from.ConfigMapRef.Name] = true
+			}
+			if from.SecretRef != nil {
+				secs[from.SecretRef.Name] = true
+			}
+		}
+	}
+
+	for _, v := range spec.Volumes {
+		if v.ConfigMap != nil {
+			cms[v.ConfigMap.Name] = true
+		}
+		if v.Secret != nil {
+			secs[v.Secret.SecretName] = true
+		}
+		if v.Projected == nil {
+			continue
+		}
+		for _, source := range v.Projected.Sources {
+			if source.ConfigMap != nil {
+				cms[source.ConfigMap.Name] = true
+			}
+			if source.Secret != nil {
+				secs[source.Secret.Name] = true
+			}
+		}
+	}
+
+	return sortedKeys(cms), sortedKeys(secs)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// configHash returns a hash of the data of every ConfigMap and Secret the
+// pod spec consumes, or "" if it consumes none. Missing objects hash
+// differently from empty ones, so creating an optional reference rolls the
+// pods as well.
+func (r *MyAppResourceReconciler) configHash(ctx context.Context, namespace string, spec *corev1.PodSpec) (string, error) {
+	configMaps, secrets := referencedObjects(spec)
+	if len(configMaps) == 0 && len(secrets) == 0 {
+		return "", nil
+	}
+
+	h := sha256.New()
+	write := func(parts ...string) {
+		for _, p := range parts {
+			h.Write([]byte(p))
+			h.Write([]byte{0})
+		}
+	}
+
+	for _, name := range configMaps {
+		cm := corev1.ConfigMap{}
+		err := r.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, &cm)
+		if client.IgnoreNotFound(err) != nil {
+			return "", err
+		}
+		write("configmap", name, boolString(err == nil))
+		for _, k := range sortedKeys(cm.Data) {
+			write(k, cm.Data[k])
+		}
+		for _, k := range sortedKeys(cm.BinaryData) {
+			write(k, string(cm.BinaryData[k]))
+		}
+	}
+	for _, name := range secrets {
+		secret := corev1.Secret{}
+		err := r.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, &secret)
+		if client.IgnoreNotFound(err) != nil {
+			return "", err
+		}
+		write("secret", name, boolString(err == nil))
+		for _, k := range sortedKeys(secret.Data) {
+			write(k, string(secret.Data[k]))
+		}
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func boolString(b bool) string {
+	if b {
+		return "found"
+	}
+	return "missing"
+}
+
+// indexReferences returns an indexer extracting the names of the ConfigMaps
+// or Secrets a MyAppResource consumes.
+func indexReferences(opts render.Options, secrets bool) client.IndexerFunc {
+	return func(o client.Object) []string {
+		mar, ok := o.(*myv1alpha1.MyAppResource)
+		if !ok {
+			return nil
+		}
+		objs, err := render.Render(*mar, opts)
+		if err != nil {
+			return nil
+		}
+		if _, err := render.ApplyOverrides(*mar, objs); err != nil {
+			return nil
+		}
+		var names []string
+		for _, obj := range objs {
+			d, ok := obj.(*appsv1.Deployment)
+			if !ok {
+				continue
+			}
+			configMaps, secretNames := referencedObjects(&d.Spec.Template.Spec)
+			if secrets {
+				names = append(names, secretNames...)
+			} else {
+				names = append(names, configMaps...)
+			}
+		}
+		return names
+	}
+}
+
+// requestsForReference returns a handler map function enqueuing every
+// MyAppResource in the object's namespace that consumes it.
+func (r *MyAppResourceReconciler) requestsForReference(indexKey string) func(context.Context, client.Object) []reconcile.Request {
+	return func(ctx context.Context, o client.Object) []reconcile.Request {
+		list := myv1alpha1.MyAppResourceList{}
+		if err := r.List(ctx, &list, client.InNamespace(o.GetNamespace()),
+			client.MatchingFields{indexKey: o.GetName()}); err != nil {
+			log.FromContext(ctx).Error(err, "Failed to list MyAppResources referencing object", "Name", o.GetName())
+			return nil
+		}
+		requests := make([]reconcile.Request, 0, len(list.Items))
+		for _, mar := range list.Items {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{Namespace: mar.Namespace, Name: mar.Name},
+			})
+		}
+		return requests
+	}
+}
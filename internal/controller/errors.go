@@ -0,0 +1,81 @@
+/*
+Copyright 2024 shiliohstuart6.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// conditionReconciled is true when the last reconciliation of the custom
+// resource succeeded. Otherwise its reason tells what failed.
+const conditionReconciled = "Reconciled"
+
+// The classes of reconcile errors.
+const (
+	// errorTransient errors are retried with backoff.
+	errorTransient = "Transient"
+	// errorTerminal errors need the spec to change and are not retried.
+	errorTerminal = "Terminal"
+	// errorOwnership errors are existing objects the controller may not take
+	// over. They are checked again every conflictRequeueAfter.
+	errorOwnership = "Ownership"
+)
+
+// invalidSpecError reports a spec that cannot be turned into objects, which
+// retrying cannot fix.
+type invalidSpecError struct {
+	reason string
+	err    error
+}
+
+func (e *invalidSpecError) Error() string {
+	return e.err.Error()
+}
+
+func (e *invalidSpecError) Unwrap() error {
+	return e.err
+}
+
+// classifyError returns the class of a reconcile error and a reason naming
+// it, suitable for a condition.
+func classifyError(err error) (class, reason string) {
+	var conflict *ownershipConflict
+	var invalid *invalidSpecError
+	switch {
+	case errors.As(err, &conflict):
+		return errorOwnership, conflict.reason
+	case errors.As(err, &invalid):
+		return errorTerminal, invalid.reason
+	case apierrors.IsConflict(err):
+		return errorTransient, "Conflict"
+	case apierrors.IsTooManyRequests(err):
+		return errorTransient, "Throttled"
+	case apierrors.IsServerTimeout(err), apierrors.IsTimeout(err), errors.Is(err, context.DeadlineExceeded):
+		return errorTransient, "Timeout"
+	case apierrors.IsInvalid(err), apierrors.IsBadRequest(err):
+		// The API server refused a rendered object, like a change to an
+		// immutable field.
+		return errorTerminal, "Rejected"
+	}
+	if reason := apierrors.ReasonForError(err); reason != "" {
+		return errorTransient, string(reason)
+	}
+	return errorTransient, "Error"
+}
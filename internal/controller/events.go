@@ -0,0 +1,165 @@
+/*
+Copyright 2024 shiliohstuart6.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/types"
+
+	myv1alpha1 "github.com/shilohstuart6/Custom-Controller.git/api/v1alpha1"
+)
+
+// eventInterval is how long an Event is not recorded again on the same custom
+// resource with the same type, reason and message.
+const eventInterval = 10 * time.Minute
+
+// eventFilter drops Events identical to one recorded within eventInterval, so
+// a reconcile loop running hot does not flood the API server. The Events
+// that pass are further aggregated by the recorder of the manager.
+type eventFilter struct {
+	mu   sync.Mutex
+	seen map[eventKey]time.Time
+}
+
+type eventKey struct {
+	uid                        types.UID
+	eventType, reason, message string
+}
+
+// allow reports whether the Event should be recorded, and remembers it if so.
+func (f *eventFilter) allow(mar *myv1alpha1.MyAppResource, eventType, reason, message string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := time.Now()
+	for k, at := range f.seen {
+		if now.Sub(at) >= eventInterval {
+			delete(f.seen, k)
+		}
+	}
+	key := eventKey{mar.UID, eventType, reason, message}
+	if _, ok := f.seen[key]; ok {
+		return false
+	}
+	if f.seen == nil {
+		f.seen = map[eventKey]time.Time{}
+	}
+	f.seen[key] = now
+	return true
+}
+
+// event records an Event on the custom resource, unless the reconciler has
+// no recorder or the same Event was recorded recently.
+func (r *MyAppResourceReconciler) event(mar *myv1alpha1.MyAppResource, eventType, reason, message string) {
+	if r.Recorder != nil && r.events.allow(mar, eventType, reason, message) {
+		r.Recorder.Event(mar, eventType, reason, message)
+	}
+}
+
+// renderFailedReason returns the reason of the Event reporting a render
+// error.
+func renderFailedReason(err error) string {
+	for _, target := range []error{resource.ErrFormatWrong, resource.ErrNumeric, resource.ErrSuffix} {
+		if errors.Is(err, target) {
+			return "InvalidQuantity"
+		}
+	}
+	return "RenderFailed"
+}
+
+// rolloutState is the last rollout state reported for a Deployment.
+type rolloutState struct {
+	generation int64
+	failed     bool
+}
+
+// recordRollout records the end or the failure of the rollout of a
+// Deployment, once per generation. Rollouts still in progress are reported
+// when they start, by apply.
+func (r *MyAppResourceReconciler) recordRollout(mar *myv1alpha1.MyAppResource, d *appsv1.Deployment) {
+	if d.Status.ObservedGeneration < d.Generation {
+		return
+	}
+	var state rolloutState
+	switch {
+	case deploymentRolloutFailed(d):
+		state = rolloutState{generation: d.Generation, failed: true}
+	case deploymentRolledOut(d):
+		state = rolloutState{generation: d.Generation}
+	default:
+		return
+	}
+
+	r.rolloutsMu.Lock()
+	last, ok := r.rollouts[d.UID]
+	if r.rollouts == nil {
+		r.rollouts = map[types.UID]rolloutState{}
+	}
+	r.rollouts[d.UID] = state
+	if ok && last == state {
+		r.rolloutsMu.Unlock()
+		return
+	}
+	started, timed := r.rolloutStarts[d.UID]
+	delete(r.rolloutStarts, d.UID)
+	r.rolloutsMu.Unlock()
+
+	if timed {
+		result := "succeeded"
+		if state.failed {
+			result = "failed"
+		}
+		rolloutDuration.WithLabelValues(result).Observe(time.Since(started).Seconds())
+	}
+	if state.failed {
+		r.event(mar, corev1.EventTypeWarning, "RolloutFailed",
+			fmt.Sprintf("Rollout of Deployment %s generation %d exceeded its progress deadline", d.Name, d.Generation))
+		return
+	}
+	r.event(mar, corev1.EventTypeNormal, "RolloutFinished",
+		fmt.Sprintf("Deployment %s generation %d rolled out", d.Name, d.Generation))
+}
+
+// startRollout notes the start of a rollout of the Deployment, to observe
+// its duration once recordRollout sees it end. Rollouts started before the
+// manager are not timed.
+func (r *MyAppResourceReconciler) startRollout(uid types.UID) {
+	r.rolloutsMu.Lock()
+	defer r.rolloutsMu.Unlock()
+	if r.rolloutStarts == nil {
+		r.rolloutStarts = map[types.UID]time.Time{}
+	}
+	r.rolloutStarts[uid] = time.Now()
+}
+
+// deploymentRolloutFailed reports whether the rollout of d exceeded its
+// progress deadline.
+func deploymentRolloutFailed(d *appsv1.Deployment) bool {
+	for _, c := range d.Status.Conditions {
+		if c.Type == appsv1.DeploymentProgressing {
+			return c.Status == corev1.ConditionFalse && c.Reason == "ProgressDeadlineExceeded"
+		}
+	}
+	return false
+}
@@ -0,0 +1,229 @@
+/*
+Copyright 2024 shiliohstuart6.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	myv1alpha1 "github.com/shilohstuart6/Custom-Controller.git/api/v1alpha1"
+	"github.com/shilohstuart6/Custom-Controller.git/pkg/render"
+)
+
+// teardownFinalizer keeps a MyAppResource around until its pods have stopped
+// and the objects to keep have been released from it.
+const teardownFinalizer = "my.api.group/teardown"
+
+// teardownRequeueAfter is how often a MyAppResource being deleted is checked
+// again while its pods terminate. Pods are not watched.
+const teardownRequeueAfter = 5 * time.Second
+
+// retainedKinds are the kinds whose objects survive the deletion of a
+// MyAppResource with the Retain deletion policy.
+var retainedKinds = []func() client.ObjectList{
+	func() client.ObjectList { return &corev1.PersistentVolumeClaimList{} },
+	func() client.ObjectList { return &corev1.SecretList{} },
+}
+
+// orphanedKinds are the kinds whose objects survive the deletion of a
+// MyAppResource with the Orphan deletion policy: every kind it renders.
+var orphanedKinds = append([]func() client.ObjectList{
+	func() client.ObjectList { return &appsv1.DeploymentList{} },
+	func() client.ObjectList { return &corev1.ServiceAccountList{} },
+	func() client.ObjectList { return &rbacv1.RoleBindingList{} },
+	func() client.ObjectList { return &networkingv1.NetworkPolicyList{} },
+	func() client.ObjectList { return &corev1.ServiceList{} },
+}, retainedKinds...)
+
+// finalize tears down a MyAppResource being deleted. Unless its objects are
+// orphaned, it scales its Deployments to zero, waits for the pods to stop so
+// redis can write its final snapshot, and lets the profile remove what it
+// created outside the cluster. It then releases the objects the deletion
+// policy keeps and removes the finalizer, leaving the other objects to the
+// garbage collector.
+func (r *MyAppResourceReconciler) finalize(ctx context.Context, mar *myv1alpha1.MyAppResource) (ctrl.Result, error) {
+	l := log.FromContext(ctx)
+
+	if !controllerutil.ContainsFinalizer(mar, teardownFinalizer) {
+		return ctrl.Result{}, nil
+	}
+
+	policy := mar.Spec.DeletionPolicy
+	keep := retainedKinds
+	switch policy {
+	case myv1alpha1.DeletionPolicyOrphan:
+		keep = orphanedKinds
+	case myv1alpha1.DeletionPolicyRetain:
+	default:
+		policy = myv1alpha1.DeletionPolicyDelete
+		keep = nil
+	}
+	l.Info("Tearing down", "DeletionPolicy", policy)
+
+	if policy != myv1alpha1.DeletionPolicyOrphan {
+		stopped, err := r.stopPods(ctx, mar)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if !stopped {
+			l.Info("Waiting for the pods to stop")
+			return ctrl.Result{RequeueAfter: teardownRequeueAfter}, nil
+		}
+
+		profile, err := render.ForResource(*mar)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if f, ok := profile.(render.Finalizer); ok {
+			if err := f.Finalize(ctx, *mar); err != nil {
+				l.Error(err, "Failed to remove external artifacts")
+				return ctrl.Result{}, err
+			}
+		}
+	}
+
+	for _, newList := range keep {
+		if err := r.release(ctx, mar, newList()); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	patch := client.MergeFromWithOptions(mar.DeepCopy(), client.MergeFromWithOptimisticLock{})
+	controllerutil.RemoveFinalizer(mar, teardownFinalizer)
+	if err := r.Patch(ctx, mar, patch); err != nil {
+		l.Error(err, "Failed to remove the finalizer")
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{}, nil
+}
+
+// stopPods scales the Deployments of the custom resource to zero and reports
+// whether their pods are gone. Pods are followed through their ReplicaSets
+// to the Deployments, so pods of other releases sharing the labels are not
+// waited for.
+func (r *MyAppResourceReconciler) stopPods(ctx context.Context, mar *myv1alpha1.MyAppResource) (bool, error) {
+	l := log.FromContext(ctx)
+	instance := client.MatchingLabels(render.Labels(*mar))
+
+	deployments := &appsv1.DeploymentList{}
+	if err := r.List(ctx, deployments, client.InNamespace(mar.Namespace), instance); err != nil {
+		l.Error(err, "Failed to list owned Deployments")
+		return false, err
+	}
+	owners := map[types.UID]bool{}
+	for i := range deployments.Items {
+		d := &deployments.Items[i]
+		if !metav1.IsControlledBy(d, mar) {
+			continue
+		}
+		owners[d.UID] = true
+		if d.Spec.Replicas != nil && *d.Spec.Replicas == 0 {
+			continue
+		}
+		l.Info("Scaling Deployment to zero", "Name", d.Name)
+		patch := client.MergeFrom(d.DeepCopy())
+		d.Spec.Replicas = ptr.To[int32](0)
+		if err := r.Patch(ctx, d, patch); client.IgnoreNotFound(err) != nil {
+			l.Error(err, "Failed to scale Deployment to zero", "Name", d.Name)
+			return false, err
+		}
+		r.event(mar, corev1.EventTypeNormal, "Scaled", "Scaled Deployment "+d.Name+" to zero replicas for deletion")
+	}
+	if len(owners) == 0 {
+		return true, nil
+	}
+
+	replicaSets := &appsv1.ReplicaSetList{}
+	if err := r.List(ctx, replicaSets, client.InNamespace(mar.Namespace), instance); err != nil {
+		l.Error(err, "Failed to list ReplicaSets")
+		return false, err
+	}
+	replicaSetUIDs := map[types.UID]bool{}
+	for i := range replicaSets.Items {
+		if ref := metav1.GetControllerOf(&replicaSets.Items[i]); ref != nil && owners[ref.UID] {
+			replicaSetUIDs[replicaSets.Items[i].UID] = true
+		}
+	}
+
+	pods := &corev1.PodList{}
+	if err := r.List(ctx, pods, client.InNamespace(mar.Namespace), instance); err != nil {
+		l.Error(err, "Failed to list pods")
+		return false, err
+	}
+	for i := range pods.Items {
+		if ref := metav1.GetControllerOf(&pods.Items[i]); ref != nil && replicaSetUIDs[ref.UID] {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// release removes the custom resource from the owner references of the
+// objects of the list's type it controls, so the garbage collector keeps
+// them.
+func (r *MyAppResourceReconciler) release(ctx context.Context, mar *myv1alpha1.MyAppResource, list client.ObjectList) error {
+	l := log.FromContext(ctx)
+
+	if err := r.List(ctx, list, client.InNamespace(mar.Namespace),
+		client.MatchingLabels(render.Labels(*mar))); err != nil {
+		l.Error(err, "Failed to list owned objects")
+		return err
+	}
+	items, err := meta.ExtractList(list)
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		obj, ok := item.(client.Object)
+		if !ok || !metav1.IsControlledBy(obj, mar) {
+			continue
+		}
+		patch := client.MergeFrom(obj.DeepCopyObject().(client.Object))
+		var refs []metav1.OwnerReference
+		for _, ref := range obj.GetOwnerReferences() {
+			if ref.UID != mar.UID {
+				refs = append(refs, ref)
+			}
+		}
+		obj.SetOwnerReferences(refs)
+		if err := r.Patch(ctx, obj, patch); client.IgnoreNotFound(err) != nil {
+			l.Error(err, "Failed to release object", "Name", obj.GetName())
+			return err
+		}
+		gvk, err := apiutil.GVKForObject(obj, r.Scheme)
+		if err != nil {
+			return err
+		}
+		l.Info("Released object", "Kind", gvk.Kind, "Name", obj.GetName())
+		r.event(mar, corev1.EventTypeNormal, "Released", "Kept "+gvk.Kind+" "+obj.GetName()+" after deletion")
+	}
+	return nil
+}
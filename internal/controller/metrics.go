@@ -0,0 +1,187 @@
+/*
+Copyright 2024 shiliohstuart6.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	myv1alpha1 "github.com/shilohstuart6/Custom-Controller.git/api/v1alpha1"
+	"github.com/shilohstuart6/Custom-Controller.git/pkg/render"
+)
+
+var (
+	// childWrites counts the rendered objects the controller applied, and
+	// those it skipped since the live object already matched.
+	childWrites = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "myappresource_child_writes_total",
+		Help: "Number of rendered objects applied or skipped as unchanged, by kind and result.",
+	}, []string{"kind", "result"})
+
+	// reconcileErrors counts the failed reconciliations by class and reason.
+	reconcileErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "myappresource_reconcile_errors_total",
+		Help: "Number of failed reconciliations, by error class and reason.",
+	}, []string{"class", "reason"})
+
+	// renderFailures counts the specs the controller could not render, or
+	// whose overrides it could not apply, by reason.
+	renderFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "myappresource_render_failures_total",
+		Help: "Number of MyAppResources that failed to render, by reason.",
+	}, []string{"reason"})
+
+	// driftCorrections counts the objects applied again because someone
+	// changed them, rather than because their rendering changed.
+	driftCorrections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "myappresource_drift_corrections_total",
+		Help: "Number of changes made to rendered objects by others that were reverted, by kind.",
+	}, []string{"kind"})
+
+	// rolloutDuration observes how long Deployments took to roll out, from
+	// the update starting the rollout to its end or failure.
+	rolloutDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "myappresource_rollout_duration_seconds",
+		Help:    "Duration of the rollouts of Deployments, by result.",
+		Buckets: prometheus.ExponentialBuckets(5, 2, 10),
+	}, []string{"result"})
+)
+
+var (
+	instancesDesc = prometheus.NewDesc("myappresource_instances",
+		"Number of MyAppResources, by namespace and redis mode.",
+		[]string{"namespace", "redis_mode"}, nil)
+	desiredReplicasDesc = prometheus.NewDesc("myappresource_desired_replicas",
+		"Number of replicas asked for by a MyAppResource.",
+		[]string{"namespace", "name"}, nil)
+	readyReplicasDesc = prometheus.NewDesc("myappresource_ready_replicas",
+		"Number of ready replicas of the Deployments of a MyAppResource.",
+		[]string{"namespace", "name"}, nil)
+	sinceLastSuccessDesc = prometheus.NewDesc("myappresource_seconds_since_last_success",
+		"Seconds since a MyAppResource was last reconciled successfully by this manager.",
+		[]string{"namespace", "name"}, nil)
+)
+
+// collectTimeout bounds the listing of the cached objects on each scrape.
+const collectTimeout = 5 * time.Second
+
+func init() {
+	metrics.Registry.MustRegister(childWrites, reconcileErrors, renderFailures, driftCorrections, rolloutDuration)
+}
+
+// instanceCollector reports the state of the MyAppResources at scrape time,
+// so that no series is left behind once a MyAppResource is deleted.
+type instanceCollector struct {
+	r *MyAppResourceReconciler
+}
+
+// Describe implements prometheus.Collector.
+func (c instanceCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- instancesDesc
+	ch <- desiredReplicasDesc
+	ch <- readyReplicasDesc
+	ch <- sinceLastSuccessDesc
+}
+
+// Collect implements prometheus.Collector.
+func (c instanceCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), collectTimeout)
+	defer cancel()
+
+	mars := myv1alpha1.MyAppResourceList{}
+	if err := c.r.List(ctx, &mars); err != nil {
+		ch <- prometheus.NewInvalidMetric(instancesDesc, err)
+		return
+	}
+	deployments := appsv1.DeploymentList{}
+	if err := c.r.List(ctx, &deployments); err != nil {
+		ch <- prometheus.NewInvalidMetric(readyReplicasDesc, err)
+		return
+	}
+	ready := map[types.UID]int32{}
+	for _, d := range deployments.Items {
+		if owner := metav1.GetControllerOf(&d); owner != nil {
+			ready[owner.UID] += d.Status.ReadyReplicas
+		}
+	}
+
+	type instances struct{ namespace, redisMode string }
+	counts := map[instances]int{}
+	now := time.Now()
+	for i := range mars.Items {
+		mar := &mars.Items[i]
+		counts[instances{mar.Namespace, redisMode(mar)}]++
+		ch <- prometheus.MustNewConstMetric(desiredReplicasDesc, prometheus.GaugeValue,
+			float64(mar.Spec.ReplicaCount), mar.Namespace, mar.Name)
+		ch <- prometheus.MustNewConstMetric(readyReplicasDesc, prometheus.GaugeValue,
+			float64(ready[mar.UID]), mar.Namespace, mar.Name)
+		if last, ok := c.r.lastSuccess(client.ObjectKeyFromObject(mar)); ok {
+			ch <- prometheus.MustNewConstMetric(sinceLastSuccessDesc, prometheus.GaugeValue,
+				now.Sub(last).Seconds(), mar.Namespace, mar.Name)
+		}
+	}
+	for key, n := range counts {
+		ch <- prometheus.MustNewConstMetric(instancesDesc, prometheus.GaugeValue, float64(n), key.namespace, key.redisMode)
+	}
+}
+
+// redisMode returns how a MyAppResource runs redis: disabled, enabled, or
+// snapshot when it also saves its data to a claim. Like the renderer, it
+// goes by the profile, which may run redis without spec.redis.enabled.
+func redisMode(mar *myv1alpha1.MyAppResource) string {
+	switch {
+	case !render.RunsRedis(*mar):
+		return "disabled"
+	case mar.Spec.Redis.SnapshotClaimName != "":
+		return "snapshot"
+	default:
+		return "enabled"
+	}
+}
+
+// recordSuccess remembers when the MyAppResource was last reconciled
+// successfully.
+func (r *MyAppResourceReconciler) recordSuccess(key types.NamespacedName) {
+	r.successesMu.Lock()
+	defer r.successesMu.Unlock()
+	if r.successes == nil {
+		r.successes = map[types.NamespacedName]time.Time{}
+	}
+	r.successes[key] = time.Now()
+}
+
+// forgetSuccess drops what recordSuccess remembered of a deleted
+// MyAppResource.
+func (r *MyAppResourceReconciler) forgetSuccess(key types.NamespacedName) {
+	r.successesMu.Lock()
+	defer r.successesMu.Unlock()
+	delete(r.successes, key)
+}
+
+func (r *MyAppResourceReconciler) lastSuccess(key types.NamespacedName) (time.Time, bool) {
+	r.successesMu.Lock()
+	defer r.successesMu.Unlock()
+	last, ok := r.successes[key]
+	return last, ok
+}
@@ -18,30 +18,103 @@ package controller
 
 import (
 	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
 
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/api/resource"
+	networkingv1 "k8s.io/api/networking/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/utils/ptr"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	crcontroller "sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/ratelimiter"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	myv1alpha1 "github.com/shilohstuart6/Custom-Controller.git/api/v1alpha1"
+	"github.com/shilohstuart6/Custom-Controller.git/internal/config"
+	"github.com/shilohstuart6/Custom-Controller.git/internal/sharding"
+	"github.com/shilohstuart6/Custom-Controller.git/internal/tracing"
+	"github.com/shilohstuart6/Custom-Controller.git/pkg/render"
 )
 
 // MyAppResourceReconciler reconciles a MyAppResource object
 type MyAppResourceReconciler struct {
 	client.Client
 	Scheme *runtime.Scheme
+
+	// RenderOptions describe the cluster to the render profiles.
+	RenderOptions render.Options
+	// Config, if set, supplies the defaults and feature gates applied on
+	// top of RenderOptions. They are reloaded while the manager runs.
+	Config *config.Store
+	// MaxConcurrentReconciles and RateLimiter tune the controller queue.
+	// Zero values keep the controller-runtime defaults.
+	MaxConcurrentReconciles int
+	RateLimiter             ratelimiter.RateLimiter
+	// Namespaces, if not empty, are the only namespaces whose
+	// MyAppResources are reconciled.
+	Namespaces []string
+	// Shard, if set, is the name of the shard of this replica. Only the
+	// MyAppResources assigned to it are reconciled, and the controller runs
+	// on every replica rather than on the leader only.
+	Shard string
+	// Recorder emits Events on the custom resources. Optional.
+	Recorder record.EventRecorder
+
+	events        eventFilter
+	rolloutsMu    sync.Mutex
+	rollouts      map[types.UID]rolloutState
+	rolloutStarts map[types.UID]time.Time
+	successesMu   sync.Mutex
+	successes     map[types.NamespacedName]time.Time
 }
 
+// conflictRequeueAfter is how long to wait before checking again whether an
+// object the controller refused to take over has gone away. Objects it does
+// not own are not watched.
+const conflictRequeueAfter = time.Minute
+
+// renameRequeueAfter is how often a custom resource whose Deployment was
+// renamed is checked again while the old Deployment waits for the new one to
+// roll out. Status changes of the new Deployment also trigger a reconcile.
+const renameRequeueAfter = 15 * time.Second
+
 //+kubebuilder:rbac:groups=my.api.group,resources=myappresources,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=my.api.group,resources=myappresources/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=my.api.group,resources=myappresources/finalizers,verbs=update
 //+kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=core,resources=serviceaccounts,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=rolebindings,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=networking.k8s.io,resources=networkpolicies,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=core,resources=configmaps;secrets,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=secrets,verbs=patch
+//+kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch
+//+kubebuilder:rbac:groups=apps,resources=replicasets,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=persistentvolumeclaims,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=core,resources=services,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -49,312 +122,300 @@ type MyAppResourceReconciler struct {
 // For more details, check Reconcile and its Result here:
 // - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.17.0/pkg/reconcile
 func (r *MyAppResourceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "Reconcile", trace.WithAttributes(tracing.RequestAttributes(req)...))
+	result, err := r.reconcile(ctx, req)
+	tracing.End(span, err)
+	return result, err
+}
+
+// reconcile does the work of Reconcile, within its span.
+func (r *MyAppResourceReconciler) reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	l := log.FromContext(ctx)
 
+	if len(r.Namespaces) > 0 && !slices.Contains(r.Namespaces, req.Namespace) {
+		l.Info("Ignoring MyAppResource outside the watched namespaces", "Name", req.Name, "Namespace", req.Namespace)
+		return ctrl.Result{}, nil
+	}
+
 	// Get the custom resource
 	mar := myv1alpha1.MyAppResource{}
 	if err := r.Get(ctx, req.NamespacedName, &mar); err != nil {
 		if client.IgnoreNotFound(err) != nil {
 			l.Error(err, "Failed to fetch MyAppResource")
+		} else {
+			r.forgetSuccess(req.NamespacedName)
 		}
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
+	trace.SpanFromContext(ctx).SetAttributes(tracing.ResourceAttributes(&mar)...)
 
-	l.Info("Reconciling", "Name", mar.Name, "Namespace", mar.Namespace)
-
-	// Create a deployment spec based on custom resource
-	l.Info("Making Deployment Spec")
-	deploymentSpec, err := r.createSpec(mar)
-	if err != nil {
-		l.Error(err, "Failed to create Deployment Spec")
-		return ctrl.Result{}, err
-	}
-	if err = ctrl.SetControllerReference(&mar, &deploymentSpec, r.Scheme); err != nil {
-		l.Error(err, "Failed to set deployment controller reference")
-		return ctrl.Result{}, err
+	if r.Shard != "" {
+		if mar.Labels[sharding.ShardLabel] != r.Shard {
+			return ctrl.Result{}, nil
+		}
+		if _, ok := mar.Labels[sharding.DrainLabel]; ok {
+			l.Info("Handing MyAppResource over to another shard", "Name", mar.Name, "Namespace", mar.Namespace)
+			return ctrl.Result{}, client.IgnoreNotFound(r.Patch(ctx, &mar, sharding.ReleasePatch()))
+		}
 	}
 
-	deploymentName := types.NamespacedName{
-		Namespace: deploymentSpec.Namespace,
-		Name:      deploymentSpec.Name,
-	}
-	// Check if deployment already exists
-	if err := r.Get(ctx, deploymentName, &appsv1.Deployment{}); err != nil {
-		if client.IgnoreNotFound(err) != nil {
-			l.Error(err, "Failed to check for existing deployment")
-			return ctrl.Result{}, err
-		}
+	l.Info("Reconciling", "Name", mar.Name, "Namespace", mar.Namespace)
 
-		// Deployment not found - create it
-		l.Info("Creating Deployment")
-		if err = r.Create(ctx, &deploymentSpec); err != nil {
-			l.Error(err, "Failed to create Deployment")
-			return ctrl.Result{}, client.IgnoreAlreadyExists(err)
+	if !mar.DeletionTimestamp.IsZero() {
+		finalizeCtx, span := tracing.Tracer().Start(ctx, "Finalize")
+		result, err := r.finalize(finalizeCtx, &mar)
+		tracing.End(span, err)
+		if err != nil {
+			class, reason := classifyError(err)
+			reconcileErrors.WithLabelValues(class, reason).Inc()
+			if class == errorTerminal {
+				return ctrl.Result{}, reconcile.TerminalError(err)
+			}
 		}
-
-		l.Info("Deployment created", "Name", mar.Name, "Namespace", mar.Namespace)
-		return ctrl.Result{}, nil
+		return result, err
 	}
 
-	// Update existing deployment
-	l.Info("Updating Deployment")
-	err = r.Update(ctx, &deploymentSpec)
+	status := mar.Status.DeepCopy()
+	result, err := r.reconcileObjects(ctx, &mar)
+	succeeded := err == nil
+	result, err = r.handleError(ctx, &mar, result, err)
+	if err := r.updateStatus(ctx, &mar, status); err != nil {
+		class, reason := classifyError(err)
+		reconcileErrors.WithLabelValues(class, reason).Inc()
+		return ctrl.Result{}, err
+	}
 	if err != nil {
-		l.Error(err, "Failed to update Deployment")
+		return result, err
+	}
+	if succeeded {
+		r.recordSuccess(req.NamespacedName)
 	}
 
-	l.Info("Deployment updated", "Name", mar.Name, "Namespace", mar.Namespace)
-	return ctrl.Result{}, nil
+	l.Info("Reconciled", "Name", mar.Name, "Namespace", mar.Namespace)
+	return result, nil
 }
 
-func (r *MyAppResourceReconciler) createSpec(mar myv1alpha1.MyAppResource) (appsv1.Deployment, error) {
-	r.defaultResourcesIfOmitted(&mar)
-	if mar.Spec.Redis.Enabled {
-		return r.createSpecWithRedis(mar)
-	}
-	return r.createSpecNoRedis(mar)
-}
+// reconcileObjects renders the custom resource and applies the objects,
+// deleting those it no longer renders.
+func (r *MyAppResourceReconciler) reconcileObjects(ctx context.Context, mar *myv1alpha1.MyAppResource) (ctrl.Result, error) {
+	l := log.FromContext(ctx)
 
-func (r *MyAppResourceReconciler) defaultResourcesIfOmitted(mar *myv1alpha1.MyAppResource) {
-	if mar.Spec.Resources.MemoryRequest == "" {
-		mar.Spec.Resources.MemoryRequest = "32Mi"
-	}
-	if mar.Spec.Resources.MemoryLimit == "" {
-		mar.Spec.Resources.MemoryLimit = "64Mi"
-	}
-	if mar.Spec.Resources.CpuRequest == "" {
-		mar.Spec.Resources.CpuRequest = "100m"
-	}
-	if mar.Spec.Resources.CpuLimit == "" {
-		mar.Spec.Resources.CpuLimit = "200m"
+	if controllerutil.AddFinalizer(mar, teardownFinalizer) {
+		if err := r.Update(ctx, mar); err != nil {
+			l.Error(err, "Failed to add the finalizer")
+			return ctrl.Result{}, err
+		}
 	}
-}
 
-func (r *MyAppResourceReconciler) createSpecNoRedis(mar myv1alpha1.MyAppResource) (appsv1.Deployment, error) {
-	memR, err := resource.ParseQuantity(mar.Spec.Resources.MemoryRequest)
+	// Render the objects making up the application
+	l.Info("Rendering objects", "Profile", render.ProfileName(*mar))
+	_, span := tracing.Tracer().Start(ctx, "Render",
+		trace.WithAttributes(attribute.String("myappresource.profile", render.ProfileName(*mar))))
+	objs, err := render.Render(*mar, r.renderOptions())
+	span.SetAttributes(attribute.Int("render.objects", len(objs)))
+	tracing.End(span, err)
 	if err != nil {
-		return appsv1.Deployment{}, err
+		l.Error(err, "Failed to render objects")
+		reason := renderFailedReason(err)
+		renderFailures.WithLabelValues(reason).Inc()
+		return ctrl.Result{}, &invalidSpecError{reason: reason, err: err}
 	}
-	memL, err := resource.ParseQuantity(mar.Spec.Resources.MemoryLimit)
+	_, span = tracing.Tracer().Start(ctx, "ApplyOverrides",
+		trace.WithAttributes(attribute.Int("myappresource.overrides", len(mar.Spec.Overrides))))
+	applied, err := render.ApplyOverrides(*mar, objs)
+	tracing.End(span, err)
 	if err != nil {
-		return appsv1.Deployment{}, err
+		l.Error(err, "Failed to apply overrides")
+		renderFailures.WithLabelValues("InvalidOverride").Inc()
+		return ctrl.Result{}, &invalidSpecError{reason: "InvalidOverride", err: err}
 	}
-	cpuR, err := resource.ParseQuantity(mar.Spec.Resources.CpuRequest)
-	if err != nil {
-		return appsv1.Deployment{}, err
+
+	unchanged := 0
+	for _, obj := range objs {
+		result, err := r.apply(ctx, mar, obj)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if result == objectUnchanged {
+			unchanged++
+		}
 	}
-	cpuL, err := resource.ParseQuantity(mar.Spec.Resources.CpuLimit)
+	if unchanged > 0 {
+		r.event(mar, corev1.EventTypeNormal, "Unchanged", fmt.Sprintf("%d of %d objects already up to date", unchanged, len(objs)))
+	}
+	pruneCtx, span := tracing.Tracer().Start(ctx, "Prune")
+	renaming, err := r.prune(pruneCtx, mar, objs)
+	tracing.End(span, err)
 	if err != nil {
-		return appsv1.Deployment{}, err
+		return ctrl.Result{}, err
 	}
 
-	d := appsv1.Deployment{
-		TypeMeta: metav1.TypeMeta{Kind: "Deployment"},
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      mar.Name,
-			Namespace: mar.Namespace,
-		},
-		Spec: appsv1.DeploymentSpec{
-			Replicas: &mar.Spec.ReplicaCount,
-			Selector: &metav1.LabelSelector{
-				MatchLabels: map[string]string{
-					"app": "myappresource",
-				},
-			},
-			Template: corev1.PodTemplateSpec{
-				ObjectMeta: metav1.ObjectMeta{
-					Labels: map[string]string{
-						"app": "myappresource",
-					},
-				},
-				Spec: corev1.PodSpec{
-					RestartPolicy: corev1.RestartPolicyAlways,
-					Containers: []corev1.Container{
-						{
-							Name:    "podinfo",
-							Image:   mar.Spec.Image.Repository + ":" + mar.Spec.Image.Tag,
-							Command: []string{"./podinfo", "--port=9898"},
-							Resources: corev1.ResourceRequirements{
-								Limits: corev1.ResourceList{
-									corev1.ResourceCPU:    cpuL,
-									corev1.ResourceMemory: memL,
-								},
-								Requests: corev1.ResourceList{
-									corev1.ResourceCPU:    cpuR,
-									corev1.ResourceMemory: memR,
-								},
-							},
-							Ports: []corev1.ContainerPort{
-								{
-									Name:          "http",
-									ContainerPort: 9898,
-									Protocol:      "TCP",
-								},
-							},
-							Env: []corev1.EnvVar{
-								{
-									Name:  "PODINFO_UI_COLOR",
-									Value: mar.Spec.UI.Color,
-								},
-								{
-									Name:  "PODINFO_UI_MESSAGE",
-									Value: mar.Spec.UI.Message,
-								},
-							},
-						},
-					},
-					Volumes: []corev1.Volume{
-						{
-							Name: "conf",
-						},
-						{
-							Name: "data",
-						},
-					},
-				},
-			},
-		},
+	mar.Status.AppliedOverrides = applied
+	meta.SetStatusCondition(&mar.Status.Conditions, metav1.Condition{
+		Type:               conditionResourceConflict,
+		Status:             metav1.ConditionFalse,
+		Reason:             "Owned",
+		Message:            "Every rendered object is controlled by this MyAppResource",
+		ObservedGeneration: mar.Generation,
+	})
+	if renaming {
+		return ctrl.Result{RequeueAfter: renameRequeueAfter}, nil
 	}
-
-	return d, nil
+	return ctrl.Result{}, nil
 }
 
-func (r *MyAppResourceReconciler) createSpecWithRedis(mar myv1alpha1.MyAppResource) (appsv1.Deployment, error) {
-	memR, err := resource.ParseQuantity(mar.Spec.Resources.MemoryRequest)
-	if err != nil {
-		return appsv1.Deployment{}, err
+// handleError sets the conditions of the custom resource from the outcome of
+// reconcileObjects, and decides how the reconciliation is retried: transient
+// errors with backoff, ownership conflicts after conflictRequeueAfter and
+// terminal errors once the spec changes.
+func (r *MyAppResourceReconciler) handleError(ctx context.Context, mar *myv1alpha1.MyAppResource,
+	result ctrl.Result, err error) (ctrl.Result, error) {
+	l := log.FromContext(ctx)
+
+	if err == nil {
+		meta.SetStatusCondition(&mar.Status.Conditions, metav1.Condition{
+			Type:               conditionReconciled,
+			Status:             metav1.ConditionTrue,
+			Reason:             "Succeeded",
+			Message:            "Every rendered object is applied",
+			ObservedGeneration: mar.Generation,
+		})
+		return result, nil
 	}
-	memL, err := resource.ParseQuantity(mar.Spec.Resources.MemoryLimit)
-	if err != nil {
-		return appsv1.Deployment{}, err
+
+	class, reason := classifyError(err)
+	reconcileErrors.WithLabelValues(class, reason).Inc()
+	meta.SetStatusCondition(&mar.Status.Conditions, metav1.Condition{
+		Type:               conditionReconciled,
+		Status:             metav1.ConditionFalse,
+		Reason:             reason,
+		Message:            err.Error(),
+		ObservedGeneration: mar.Generation,
+	})
+
+	switch class {
+	case errorOwnership:
+		l.Info("Refusing to take over existing object", "Reason", reason, "Conflict", err.Error())
+		r.event(mar, corev1.EventTypeWarning, conditionResourceConflict, err.Error())
+		meta.SetStatusCondition(&mar.Status.Conditions, metav1.Condition{
+			Type:               conditionResourceConflict,
+			Status:             metav1.ConditionTrue,
+			Reason:             reason,
+			Message:            err.Error(),
+			ObservedGeneration: mar.Generation,
+		})
+		return ctrl.Result{RequeueAfter: conflictRequeueAfter}, nil
+	case errorTerminal:
+		r.event(mar, corev1.EventTypeWarning, reason, err.Error())
+		return ctrl.Result{}, reconcile.TerminalError(err)
 	}
-	cpuR, err := resource.ParseQuantity(mar.Spec.Resources.CpuRequest)
-	if err != nil {
-		return appsv1.Deployment{}, err
+	l.Info("Retrying after transient error", "Reason", reason, "Error", err.Error())
+	return ctrl.Result{}, err
+}
+
+// updateStatus writes the status of the custom resource if it differs from
+// the one it was read with. On conflicts, the status is written again onto
+// the latest version of the resource.
+func (r *MyAppResourceReconciler) updateStatus(ctx context.Context, mar *myv1alpha1.MyAppResource,
+	old *myv1alpha1.MyAppResourceStatus) error {
+	if equality.Semantic.DeepEqual(*old, mar.Status) {
+		return nil
 	}
-	cpuL, err := resource.ParseQuantity(mar.Spec.Resources.CpuLimit)
+	status := mar.Status
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		err := r.Status().Update(ctx, mar)
+		if apierrors.IsConflict(err) {
+			if err := r.Get(ctx, client.ObjectKeyFromObject(mar), mar); err != nil {
+				return err
+			}
+			mar.Status = status
+		}
+		return err
+	})
 	if err != nil {
-		return appsv1.Deployment{}, err
+		log.FromContext(ctx).Error(err, "Failed to update MyAppResource status")
+		return err
 	}
+	return nil
+}
 
-	d := appsv1.Deployment{
-		TypeMeta: metav1.TypeMeta{Kind: "Deployment"},
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      mar.Name,
-			Namespace: mar.Namespace,
-		},
-		Spec: appsv1.DeploymentSpec{
-			Replicas: &mar.Spec.ReplicaCount,
-			Selector: &metav1.LabelSelector{
-				MatchLabels: map[string]string{
-					"app": "myappresource",
-				},
-			},
-			Template: corev1.PodTemplateSpec{
-				ObjectMeta: metav1.ObjectMeta{
-					Labels: map[string]string{
-						"app": "myappresource",
-					},
-				},
-				Spec: corev1.PodSpec{
-					RestartPolicy: corev1.RestartPolicyAlways,
-					Containers: []corev1.Container{
-						{
-							Name:    "redis",
-							Image:   "redis:latest",
-							Command: []string{"redis-server"},
-							Resources: corev1.ResourceRequirements{
-								Limits: corev1.ResourceList{
-									corev1.ResourceCPU:    cpuL,
-									corev1.ResourceMemory: memL,
-								},
-								Requests: corev1.ResourceList{
-									corev1.ResourceCPU:    cpuR,
-									corev1.ResourceMemory: memR,
-								},
-							},
-							Ports: []corev1.ContainerPort{
-								{
-									Name:          "client",
-									ContainerPort: 6379,
-									Protocol:      "TCP",
-								},
-							},
-							VolumeMounts: []corev1.VolumeMount{
-								{
-									Name:      "conf",
-									MountPath: "/conf",
-								},
-								{
-									Name:      "data",
-									MountPath: "/data",
-								},
-							},
-						},
-						{
-							Name:    "podinfo",
-							Image:   mar.Spec.Image.Repository + ":" + mar.Spec.Image.Tag,
-							Command: []string{"./podinfo", "--port=9898"},
-							Resources: corev1.ResourceRequirements{
-								Limits: corev1.ResourceList{
-									corev1.ResourceCPU:    cpuL,
-									corev1.ResourceMemory: memL,
-								},
-								Requests: corev1.ResourceList{
-									corev1.ResourceCPU:    cpuR,
-									corev1.ResourceMemory: memR,
-								},
-							},
-							Ports: []corev1.ContainerPort{
-								{
-									Name:          "http",
-									ContainerPort: 9898,
-									Protocol:      "TCP",
-								},
-							},
-							Env: []corev1.EnvVar{
-								{
-									Name: "POD_IP",
-									ValueFrom: &corev1.EnvVarSource{
-										FieldRef: &corev1.ObjectFieldSelector{
-											FieldPath: "status.podIP",
-										},
-									},
-								},
-								{
-									Name:  "PODINFO_UI_COLOR",
-									Value: mar.Spec.UI.Color,
-								},
-								{
-									Name:  "PODINFO_UI_MESSAGE",
-									Value: mar.Spec.UI.Message,
-								},
-								{
-									Name:  "PODINFO_CACHE_SERVER",
-									Value: "tcp://$(POD_IP):6379",
-								},
-							},
-						},
-					},
-					Volumes: []corev1.Volume{
-						{
-							Name: "conf",
-						},
-						{
-							Name: "data",
-						},
-					},
-				},
-			},
-		},
+// renderOptions returns the options the custom resources are rendered with.
+func (r *MyAppResourceReconciler) renderOptions() render.Options {
+	if r.Config == nil {
+		return r.RenderOptions
 	}
-
-	return d, nil
+	return r.Config.RenderOptions(r.RenderOptions)
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *MyAppResourceReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
-		For(&myv1alpha1.MyAppResource{}).
-		Complete(r)
+	indexer := mgr.GetFieldIndexer()
+	if err := indexer.IndexField(context.Background(), &myv1alpha1.MyAppResource{},
+		configMapIndexKey, indexReferences(r.RenderOptions, false)); err != nil {
+		return err
+	}
+	if err := indexer.IndexField(context.Background(), &myv1alpha1.MyAppResource{},
+		secretIndexKey, indexReferences(r.RenderOptions, true)); err != nil {
+		return err
+	}
+
+	if err := metrics.Registry.Register(instanceCollector{r}); err != nil {
+		return err
+	}
+
+	options := crcontroller.Options{
+		MaxConcurrentReconciles: r.MaxConcurrentReconciles,
+		RateLimiter:             r.RateLimiter,
+	}
+	if r.Shard != "" {
+		options.NeedLeaderElection = ptr.To(false)
+	}
+	b := ctrl.NewControllerManagedBy(mgr).
+		WithOptions(options).
+		// Status and metadata-only updates of the custom resource, like
+		// those made by the controller itself, do not change what it renders.
+		// Label changes may hand it over to another shard.
+		For(&myv1alpha1.MyAppResource{}, builder.WithPredicates(
+			predicate.Or(predicate.GenerationChangedPredicate{}, predicate.LabelChangedPredicate{}))).
+		Owns(&appsv1.Deployment{}, builder.WithPredicates(childChanged)).
+		Owns(&corev1.ServiceAccount{}, builder.WithPredicates(childChanged)).
+		Owns(&rbacv1.RoleBinding{}, builder.WithPredicates(childChanged)).
+		Owns(&networkingv1.NetworkPolicy{}, builder.WithPredicates(childChanged)).
+		Owns(&corev1.PersistentVolumeClaim{}, builder.WithPredicates(childChanged)).
+		Owns(&corev1.Service{}, builder.WithPredicates(childChanged)).
+		Watches(&corev1.ConfigMap{},
+			handler.EnqueueRequestsFromMapFunc(r.requestsForReference(configMapIndexKey))).
+		Watches(&corev1.Secret{},
+			handler.EnqueueRequestsFromMapFunc(r.requestsForReference(secretIndexKey)))
+	if r.Config != nil {
+		// Render every custom resource again when reloaded defaults or
+		// feature gates may change the result. Reloads arriving while one
+		// is pending are coalesced, and none blocks the Store, even while
+		// the controller waits to be elected.
+		reloads := make(chan event.GenericEvent, 1)
+		r.Config.OnChange(func() {
+			select {
+			case reloads <- event.GenericEvent{Object: &myv1alpha1.MyAppResource{}}:
+			default:
+			}
+		})
+		b = b.WatchesRawSource(&source.Channel{Source: reloads}, handler.EnqueueRequestsFromMapFunc(r.requestsForAll))
+	}
+	return b.Complete(r)
+}
+
+// requestsForAll returns a request for every custom resource in the cache.
+func (r *MyAppResourceReconciler) requestsForAll(ctx context.Context, _ client.Object) []reconcile.Request {
+	list := myv1alpha1.MyAppResourceList{}
+	if err := r.List(ctx, &list); err != nil {
+		log.FromContext(ctx).Error(err, "Failed to list MyAppResources")
+		return nil
+	}
+	requests := make([]reconcile.Request, 0, len(list.Items))
+	for _, mar := range list.Items {
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{Namespace: mar.Namespace, Name: mar.Name},
+		})
+	}
+	return requests
 }
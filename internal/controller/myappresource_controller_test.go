@@ -18,19 +18,53 @@ package controller
 
 import (
 	"context"
+	"strings"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	myv1alpha1 "github.com/shilohstuart6/Custom-Controller.git/api/v1alpha1"
+	"github.com/shilohstuart6/Custom-Controller.git/internal/sharding"
+	"github.com/shilohstuart6/Custom-Controller.git/internal/tracing"
+	"github.com/shilohstuart6/Custom-Controller.git/pkg/render"
 )
 
+// recordedEvents drains the Events recorded so far.
+func recordedEvents(recorder *record.FakeRecorder) []string {
+	var events []string
+	for {
+		select {
+		case e := <-recorder.Events:
+			events = append(events, e)
+		default:
+			return events
+		}
+	}
+}
+
 var _ = Describe("MyAppResource Controller", func() {
 	Context("When reconciling a resource", func() {
 		const resourceName = "test-resource"
@@ -78,10 +112,39 @@ var _ = Describe("MyAppResource Controller", func() {
 		AfterEach(func() {
 			resource := &myv1alpha1.MyAppResource{}
 			err := k8sClient.Get(ctx, typeNamespacedName, resource)
+			if errors.IsNotFound(err) {
+				err = nil
+			} else if err == nil {
+				By("Cleanup the specific resource instance MyAppResource")
+				Expect(k8sClient.Delete(ctx, resource)).To(Succeed())
+
+				By("Running the teardown the finalizer waits for")
+				controllerReconciler := &MyAppResourceReconciler{
+					Client: k8sClient,
+					Scheme: k8sClient.Scheme(),
+				}
+				_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{
+					NamespacedName: typeNamespacedName,
+				})
+			}
 			Expect(err).NotTo(HaveOccurred())
 
-			By("Cleanup the specific resource instance MyAppResource")
-			Expect(k8sClient.Delete(ctx, resource)).To(Succeed())
+			By("Cleanup the objects it owned, as envtest runs no garbage collector")
+			for _, list := range []client.ObjectList{
+				&appsv1.DeploymentList{},
+				&corev1.ServiceList{},
+				&corev1.ServiceAccountList{},
+				&rbacv1.RoleBindingList{},
+				&networkingv1.NetworkPolicyList{},
+			} {
+				Expect(k8sClient.List(ctx, list, client.InNamespace("default"),
+					client.MatchingLabels{render.InstanceLabel: resourceName})).To(Succeed())
+				items, err := meta.ExtractList(list)
+				Expect(err).NotTo(HaveOccurred())
+				for _, item := range items {
+					Expect(client.IgnoreNotFound(k8sClient.Delete(ctx, item.(client.Object)))).To(Succeed())
+				}
+			}
 		})
 		It("should successfully reconcile the resource", func() {
 			By("Reconciling the created resource")
@@ -230,5 +293,1132 @@ var _ = Describe("MyAppResource Controller", func() {
 				}
 			}
 		})
+		It("should apply scheduling settings", func() {
+			By("Reconciling a resource with scheduling settings")
+			controllerReconciler := &MyAppResourceReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+
+			Expect(k8sClient.Get(ctx, typeNamespacedName, myappresource)).To(Succeed())
+			myappresource.Spec.Scheduling = myv1alpha1.Scheduling{
+				NodeSelector: map[string]string{"disktype": "ssd"},
+				Tolerations: []corev1.Toleration{
+					{Key: "dedicated", Operator: corev1.TolerationOpEqual, Value: "podinfo", Effect: corev1.TaintEffectNoSchedule},
+				},
+				TopologySpreadConstraints: []corev1.TopologySpreadConstraint{
+					{MaxSkew: 2, TopologyKey: "kubernetes.io/hostname", WhenUnsatisfiable: corev1.DoNotSchedule},
+				},
+				PriorityClassName: "high-priority",
+				SpreadAcrossZones: true,
+			}
+			Expect(k8sClient.Update(ctx, myappresource)).To(Succeed())
+
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			deployment := appsv1.Deployment{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, &deployment)).To(Succeed())
+
+			podSpec := deployment.Spec.Template.Spec
+			Expect(deployment.Spec.Template.Labels).To(HaveKeyWithValue("app.kubernetes.io/instance", resourceName))
+			Expect(podSpec.NodeSelector).To(Equal(map[string]string{"disktype": "ssd"}))
+			Expect(podSpec.Tolerations).To(HaveLen(1))
+			Expect(podSpec.PriorityClassName).To(Equal("high-priority"))
+
+			// The user-supplied hostname constraint wins over the generated one
+			Expect(podSpec.TopologySpreadConstraints).To(HaveLen(2))
+			Expect(podSpec.TopologySpreadConstraints[0].TopologyKey).To(Equal("kubernetes.io/hostname"))
+			Expect(podSpec.TopologySpreadConstraints[0].MaxSkew).To(BeEquivalentTo(2))
+			Expect(podSpec.TopologySpreadConstraints[1].TopologyKey).To(Equal("topology.kubernetes.io/zone"))
+			Expect(podSpec.TopologySpreadConstraints[1].LabelSelector.MatchLabels).To(Equal(map[string]string{
+				"app":                        "myappresource",
+				"app.kubernetes.io/instance": resourceName,
+			}))
+
+			By("Removing scheduling settings from the resource")
+			Expect(k8sClient.Get(ctx, typeNamespacedName, myappresource)).To(Succeed())
+			myappresource.Spec.Scheduling.NodeSelector = nil
+			myappresource.Spec.Scheduling.Tolerations = nil
+			myappresource.Spec.Scheduling.PriorityClassName = ""
+			Expect(k8sClient.Update(ctx, myappresource)).To(Succeed())
+
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(k8sClient.Get(ctx, typeNamespacedName, &deployment)).To(Succeed())
+			podSpec = deployment.Spec.Template.Spec
+			Expect(podSpec.NodeSelector).To(BeEmpty())
+			Expect(podSpec.Tolerations).To(BeEmpty())
+			Expect(podSpec.PriorityClassName).To(BeEmpty())
+		})
+		It("should harden the generated pod by default", func() {
+			By("Reconciling a resource without security overrides")
+			controllerReconciler := &MyAppResourceReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+
+			Expect(k8sClient.Get(ctx, typeNamespacedName, myappresource)).To(Succeed())
+			myappresource.Spec.SecurityContext = myv1alpha1.SecurityContext{
+				Pod: &corev1.PodSecurityContext{FSGroup: ptr.To[int64](2000)},
+			}
+			Expect(k8sClient.Update(ctx, myappresource)).To(Succeed())
+
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			deployment := appsv1.Deployment{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, &deployment)).To(Succeed())
+
+			podSC := deployment.Spec.Template.Spec.SecurityContext
+			Expect(podSC.RunAsNonRoot).To(Equal(ptr.To(true)))
+			Expect(podSC.SeccompProfile.Type).To(Equal(corev1.SeccompProfileTypeRuntimeDefault))
+			Expect(podSC.FSGroup).To(Equal(ptr.To[int64](2000)))
+			for _, c := range deployment.Spec.Template.Spec.Containers {
+				Expect(c.SecurityContext.ReadOnlyRootFilesystem).To(Equal(ptr.To(true)))
+				Expect(c.SecurityContext.AllowPrivilegeEscalation).To(Equal(ptr.To(false)))
+				Expect(c.SecurityContext.Capabilities.Drop).To(ConsistOf(corev1.Capability("ALL")))
+				Expect(c.SecurityContext.RunAsUser).NotTo(BeNil())
+			}
+			for _, v := range deployment.Spec.Template.Spec.Volumes {
+				Expect(v.EmptyDir).NotTo(BeNil())
+			}
+		})
+		It("should create and own a dedicated ServiceAccount", func() {
+			By("Reconciling a resource that asks for its own ServiceAccount")
+			controllerReconciler := &MyAppResourceReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+
+			Expect(k8sClient.Get(ctx, typeNamespacedName, myappresource)).To(Succeed())
+			myappresource.Spec.ServiceAccount = myv1alpha1.ServiceAccount{
+				Create:         true,
+				Annotations:    map[string]string{"iam.gke.io/gcp-service-account": "podinfo@project.iam.gserviceaccount.com"},
+				AutomountToken: ptr.To(false),
+				Roles:          []myv1alpha1.RoleReference{{Kind: "ClusterRole", Name: "view"}},
+			}
+			Expect(k8sClient.Update(ctx, myappresource)).To(Succeed())
+
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			sa := corev1.ServiceAccount{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, &sa)).To(Succeed())
+			Expect(sa.Annotations).To(HaveKey("iam.gke.io/gcp-service-account"))
+			Expect(metav1.IsControlledBy(&sa, myappresource)).To(BeTrue())
+
+			rb := rbacv1.RoleBinding{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{
+				Name:      resourceName + "-clusterrole-view",
+				Namespace: "default",
+			}, &rb)).To(Succeed())
+			Expect(rb.RoleRef.Kind).To(Equal("ClusterRole"))
+			Expect(rb.Subjects).To(ConsistOf(rbacv1.Subject{
+				Kind:      rbacv1.ServiceAccountKind,
+				Name:      resourceName,
+				Namespace: "default",
+			}))
+
+			deployment := appsv1.Deployment{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, &deployment)).To(Succeed())
+			Expect(deployment.Spec.Template.Spec.ServiceAccountName).To(Equal(resourceName))
+			Expect(deployment.Spec.Template.Spec.AutomountServiceAccountToken).To(Equal(ptr.To(false)))
+
+			By("Turning off ServiceAccount creation")
+			Expect(k8sClient.Get(ctx, typeNamespacedName, myappresource)).To(Succeed())
+			myappresource.Spec.ServiceAccount = myv1alpha1.ServiceAccount{}
+			Expect(k8sClient.Update(ctx, myappresource)).To(Succeed())
+
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			err = k8sClient.Get(ctx, typeNamespacedName, &sa)
+			Expect(errors.IsNotFound(err)).To(BeTrue())
+			err = k8sClient.Get(ctx, client.ObjectKeyFromObject(&rb), &rb)
+			Expect(errors.IsNotFound(err)).To(BeTrue())
+		})
+		It("should generate NetworkPolicies isolating the instance", func() {
+			By("Reconciling a resource with network policies enabled")
+			controllerReconciler := &MyAppResourceReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+
+			Expect(k8sClient.Get(ctx, typeNamespacedName, myappresource)).To(Succeed())
+			myappresource.Spec.Redis.Enabled = true
+			myappresource.Spec.NetworkPolicy = myv1alpha1.NetworkPolicy{
+				Enabled: true,
+				Ingress: []networkingv1.NetworkPolicyPeer{
+					{NamespaceSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"kubernetes.io/metadata.name": "ingress-nginx"},
+					}},
+				},
+			}
+			Expect(k8sClient.Update(ctx, myappresource)).To(Succeed())
+
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			np := networkingv1.NetworkPolicy{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, &np)).To(Succeed())
+			Expect(np.Spec.PodSelector.MatchLabels).To(HaveKeyWithValue("app.kubernetes.io/instance", resourceName))
+			Expect(np.Spec.PolicyTypes).To(ConsistOf(networkingv1.PolicyTypeIngress))
+			Expect(np.Spec.Ingress).To(HaveLen(1))
+			Expect(np.Spec.Ingress[0].From).To(Equal(myappresource.Spec.NetworkPolicy.Ingress))
+			Expect(np.Spec.Ingress[0].Ports[0].Port.StrVal).To(Equal("http"))
+
+			redisName := types.NamespacedName{Name: resourceName + "-redis", Namespace: "default"}
+			Expect(k8sClient.Get(ctx, redisName, &np)).To(Succeed())
+			Expect(np.Spec.Ingress[0].Ports[0].Port.StrVal).To(Equal("client"))
+			Expect(np.Spec.Ingress[0].From[0].PodSelector.MatchLabels).To(
+				HaveKeyWithValue("app.kubernetes.io/instance", resourceName))
+
+			By("Disabling network policies")
+			Expect(k8sClient.Get(ctx, typeNamespacedName, myappresource)).To(Succeed())
+			myappresource.Spec.NetworkPolicy.Enabled = false
+			Expect(k8sClient.Update(ctx, myappresource)).To(Succeed())
+
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(errors.IsNotFound(k8sClient.Get(ctx, typeNamespacedName, &np))).To(BeTrue())
+			Expect(errors.IsNotFound(k8sClient.Get(ctx, redisName, &np))).To(BeTrue())
+		})
+		It("should merge user environment into the podinfo container", func() {
+			By("Reconciling a resource with env and envFrom")
+			controllerReconciler := &MyAppResourceReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+
+			Expect(k8sClient.Get(ctx, typeNamespacedName, myappresource)).To(Succeed())
+			myappresource.Spec.Env = []corev1.EnvVar{
+				{Name: "PODINFO_UI_MESSAGE", Value: "from env"},
+				{Name: "NODE_NAME", ValueFrom: &corev1.EnvVarSource{
+					FieldRef: &corev1.ObjectFieldSelector{FieldPath: "spec.nodeName"},
+				}},
+				{Name: "API_TOKEN", ValueFrom: &corev1.EnvVarSource{
+					SecretKeyRef: &corev1.SecretKeySelector{
+						LocalObjectReference: corev1.LocalObjectReference{Name: "podinfo-secrets"},
+						Key:                  "token",
+					},
+				}},
+			}
+			myappresource.Spec.EnvFrom = []corev1.EnvFromSource{
+				{ConfigMapRef: &corev1.ConfigMapEnvSource{
+					LocalObjectReference: corev1.LocalObjectReference{Name: "podinfo-config"},
+				}},
+			}
+			Expect(k8sClient.Update(ctx, myappresource)).To(Succeed())
+
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			deployment := appsv1.Deployment{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, &deployment)).To(Succeed())
+
+			var podinfo corev1.Container
+			for _, c := range deployment.Spec.Template.Spec.Containers {
+				if c.Name == "podinfo" {
+					podinfo = c
+				}
+			}
+			env := map[string]corev1.EnvVar{}
+			for _, e := range podinfo.Env {
+				Expect(env).NotTo(HaveKey(e.Name))
+				env[e.Name] = e
+			}
+			Expect(env["PODINFO_UI_MESSAGE"].Value).To(Equal("from env"))
+			Expect(env["PODINFO_UI_COLOR"].Value).To(Equal(myappresource.Spec.UI.Color))
+			Expect(env["NODE_NAME"].ValueFrom.FieldRef.FieldPath).To(Equal("spec.nodeName"))
+			Expect(env["API_TOKEN"].ValueFrom.SecretKeyRef.Key).To(Equal("token"))
+			Expect(podinfo.EnvFrom).To(Equal(myappresource.Spec.EnvFrom))
+		})
+		It("should roll the pods when referenced configuration changes", func() {
+			By("Reconciling a resource consuming a ConfigMap")
+			controllerReconciler := &MyAppResourceReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+
+			cm := &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: "podinfo-settings", Namespace: "default"},
+				Data:       map[string]string{"PODINFO_UI_LOGO": "https://example.com/logo.png"},
+			}
+			Expect(k8sClient.Create(ctx, cm)).To(Succeed())
+			defer func() {
+				Expect(k8sClient.Delete(ctx, cm)).To(Succeed())
+			}()
+
+			Expect(k8sClient.Get(ctx, typeNamespacedName, myappresource)).To(Succeed())
+			myappresource.Spec.EnvFrom = []corev1.EnvFromSource{
+				{ConfigMapRef: &corev1.ConfigMapEnvSource{
+					LocalObjectReference: corev1.LocalObjectReference{Name: cm.Name},
+				}},
+			}
+			Expect(k8sClient.Update(ctx, myappresource)).To(Succeed())
+
+			hash := func() string {
+				_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+					NamespacedName: typeNamespacedName,
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				deployment := appsv1.Deployment{}
+				Expect(k8sClient.Get(ctx, typeNamespacedName, &deployment)).To(Succeed())
+				return deployment.Spec.Template.Annotations["my.api.group/config-hash"]
+			}
+
+			first := hash()
+			Expect(first).NotTo(BeEmpty())
+			Expect(hash()).To(Equal(first))
+
+			By("Changing the ConfigMap data")
+			cm.Data["PODINFO_UI_LOGO"] = "https://example.com/other.png"
+			Expect(k8sClient.Update(ctx, cm)).To(Succeed())
+			Expect(hash()).NotTo(Equal(first))
+		})
+		It("should mount user volumes and the persistent data claim", func() {
+			By("Reconciling a resource with volumes and persistence")
+			controllerReconciler := &MyAppResourceReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+
+			Expect(k8sClient.Get(ctx, typeNamespacedName, myappresource)).To(Succeed())
+			myappresource.Spec.Volumes = []myv1alpha1.Volume{
+				{Name: "settings", Secret: &corev1.SecretVolumeSource{SecretName: "podinfo-tls"}},
+			}
+			myappresource.Spec.VolumeMounts = []corev1.VolumeMount{
+				{Name: "settings", MountPath: "/etc/tls", ReadOnly: true},
+			}
+			myappresource.Spec.Persistence = myv1alpha1.Persistence{Enabled: true, Size: "2Gi"}
+			Expect(k8sClient.Update(ctx, myappresource)).To(Succeed())
+
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			pvc := corev1.PersistentVolumeClaim{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{
+				Name:      resourceName + "-data",
+				Namespace: "default",
+			}, &pvc)).To(Succeed())
+			Expect(pvc.Spec.Resources.Requests.Storage().String()).To(Equal("2Gi"))
+			Expect(pvc.Spec.AccessModes).To(ConsistOf(corev1.ReadWriteOnce))
+
+			deployment := appsv1.Deployment{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, &deployment)).To(Succeed())
+
+			volumes := map[string]corev1.Volume{}
+			for _, v := range deployment.Spec.Template.Spec.Volumes {
+				volumes[v.Name] = v
+			}
+			Expect(volumes["settings"].Secret.SecretName).To(Equal("podinfo-tls"))
+			Expect(volumes["podinfo-data"].PersistentVolumeClaim.ClaimName).To(Equal(pvc.Name))
+
+			for _, c := range deployment.Spec.Template.Spec.Containers {
+				if c.Name != "podinfo" {
+					continue
+				}
+				Expect(c.VolumeMounts).To(ContainElements(
+					corev1.VolumeMount{Name: "settings", MountPath: "/etc/tls", ReadOnly: true},
+					corev1.VolumeMount{Name: "podinfo-data", MountPath: "/data"},
+				))
+			}
+		})
+		It("should map podinfo settings to flags, ports and the Service", func() {
+			By("Reconciling a resource with podinfo settings")
+			controllerReconciler := &MyAppResourceReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+
+			Expect(k8sClient.Get(ctx, typeNamespacedName, myappresource)).To(Succeed())
+			myappresource.Spec.UI.Logo = "https://example.com/logo.png"
+			myappresource.Spec.Podinfo = myv1alpha1.Podinfo{
+				LogLevel:    "debug",
+				HTTPPort:    8080,
+				GRPCPort:    9999,
+				BackendURLs: []string{"http://backend:9898/echo"},
+				RandomDelay: &myv1alpha1.RandomDelay{Min: 1, Max: 3, Unit: "ms"},
+				RandomError: true,
+			}
+			Expect(k8sClient.Update(ctx, myappresource)).To(Succeed())
+
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			deployment := appsv1.Deployment{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, &deployment)).To(Succeed())
+
+			var podinfo corev1.Container
+			for _, c := range deployment.Spec.Template.Spec.Containers {
+				if c.Name == "podinfo" {
+					podinfo = c
+				}
+			}
+			Expect(podinfo.Command).To(Equal([]string{
+				"./podinfo", "--port=8080", "--level=debug", "--grpc-port=9999",
+				"--backend-url=http://backend:9898/echo",
+				"--random-delay", "--random-delay-min=1", "--random-delay-max=3", "--random-delay-unit=ms",
+				"--random-error",
+			}))
+			Expect(podinfo.Ports).To(ConsistOf(
+				corev1.ContainerPort{Name: "http", ContainerPort: 8080, Protocol: "TCP"},
+				corev1.ContainerPort{Name: "grpc", ContainerPort: 9999, Protocol: "TCP"},
+			))
+			Expect(podinfo.Env).To(ContainElement(corev1.EnvVar{Name: "PODINFO_UI_LOGO", Value: "https://example.com/logo.png"}))
+
+			svc := corev1.Service{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, &svc)).To(Succeed())
+			Expect(svc.Spec.Selector).To(HaveKeyWithValue("app.kubernetes.io/instance", resourceName))
+			Expect(svc.Spec.Ports).To(HaveLen(2))
+			Expect(svc.Spec.Ports[0].Port).To(BeEquivalentTo(8080))
+			Expect(svc.Spec.Ports[1].Name).To(Equal("grpc"))
+			Expect(svc.Spec.Ports[1].Port).To(BeEquivalentTo(9999))
+		})
+		It("should run arbitrary images with the generic profile", func() {
+			By("Reconciling a resource with the generic profile")
+			controllerReconciler := &MyAppResourceReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+
+			Expect(k8sClient.Get(ctx, typeNamespacedName, myappresource)).To(Succeed())
+			myappresource.Spec.Redis.Enabled = false
+			myappresource.Spec.Profile = "generic"
+			myappresource.Spec.Image = myv1alpha1.Image{Repository: "nginxinc/nginx-unprivileged", Tag: "1.25"}
+			myappresource.Spec.App = myv1alpha1.App{
+				Command: []string{"nginx"},
+				Args:    []string{"-g", "daemon off;"},
+				Ports:   []corev1.ContainerPort{{Name: "web", ContainerPort: 8080, Protocol: "TCP"}},
+			}
+			myappresource.Spec.Env = []corev1.EnvVar{{Name: "GREETING", Value: "hi"}}
+			Expect(k8sClient.Update(ctx, myappresource)).To(Succeed())
+
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			deployment := appsv1.Deployment{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, &deployment)).To(Succeed())
+			Expect(deployment.Spec.Template.Spec.Containers).To(HaveLen(1))
+
+			app := deployment.Spec.Template.Spec.Containers[0]
+			Expect(app.Name).To(Equal("app"))
+			Expect(app.Image).To(Equal("nginxinc/nginx-unprivileged:1.25"))
+			Expect(app.Command).To(Equal([]string{"nginx"}))
+			Expect(app.Args).To(Equal([]string{"-g", "daemon off;"}))
+			Expect(app.Ports).To(Equal(myappresource.Spec.App.Ports))
+			Expect(app.Env).To(Equal([]corev1.EnvVar{{Name: "GREETING", Value: "hi"}}))
+
+			svc := corev1.Service{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, &svc)).To(Succeed())
+			Expect(svc.Spec.Ports).To(HaveLen(1))
+			Expect(svc.Spec.Ports[0].Name).To(Equal("web"))
+			Expect(svc.Spec.Ports[0].Port).To(BeEquivalentTo(8080))
+		})
+		It("should add sidecars and init containers to the pod", func() {
+			By("Reconciling a resource with a sidecar and an init container")
+			controllerReconciler := &MyAppResourceReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+
+			Expect(k8sClient.Get(ctx, typeNamespacedName, myappresource)).To(Succeed())
+			myappresource.Spec.Sidecars = []corev1.Container{{Name: "shipper", Image: "fluent/fluent-bit:3.0"}}
+			myappresource.Spec.InitContainers = []corev1.Container{{
+				Name:    "warm-up",
+				Image:   "busybox:1.36",
+				Command: []string{"sh", "-c", "sleep 1"},
+			}}
+			Expect(k8sClient.Update(ctx, myappresource)).To(Succeed())
+
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			deployment := appsv1.Deployment{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, &deployment)).To(Succeed())
+			spec := deployment.Spec.Template.Spec
+			Expect(spec.Containers[len(spec.Containers)-1].Name).To(Equal("shipper"))
+			Expect(spec.InitContainers).To(HaveLen(1))
+			Expect(spec.InitContainers[0].Name).To(Equal("warm-up"))
+			Expect(*spec.InitContainers[0].SecurityContext.AllowPrivilegeEscalation).To(BeFalse())
+		})
+		It("should apply overrides and record them in status", func() {
+			By("Reconciling a resource patching the Deployment")
+			controllerReconciler := &MyAppResourceReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+
+			Expect(k8sClient.Get(ctx, typeNamespacedName, myappresource)).To(Succeed())
+			myappresource.Spec.Overrides = []myv1alpha1.Override{{
+				Target: myv1alpha1.OverrideTarget{Kind: "Deployment"},
+				Patch:  "spec:\n  minReadySeconds: 10\n",
+			}}
+			Expect(k8sClient.Update(ctx, myappresource)).To(Succeed())
+
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			deployment := appsv1.Deployment{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, &deployment)).To(Succeed())
+			Expect(deployment.Spec.MinReadySeconds).To(BeEquivalentTo(10))
+
+			Expect(k8sClient.Get(ctx, typeNamespacedName, myappresource)).To(Succeed())
+			Expect(myappresource.Status.AppliedOverrides).To(HaveLen(1))
+			Expect(myappresource.Status.AppliedOverrides[0].Name).To(Equal(resourceName))
+			Expect(myappresource.Status.AppliedOverrides[0].Type).To(Equal(myv1alpha1.StrategicMergeOverride))
+		})
+		It("should keep fields set by others and skip unchanged objects", func() {
+			By("Annotating the Deployment outside of the controller")
+			controllerReconciler := &MyAppResourceReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			deployment := appsv1.Deployment{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, &deployment)).To(Succeed())
+			metav1.SetMetaDataAnnotation(&deployment.ObjectMeta, "team.example.com/owner", "platform")
+			Expect(k8sClient.Update(ctx, &deployment)).To(Succeed())
+			resourceVersion := deployment.ResourceVersion
+
+			By("Reconciling again without changing the resource")
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(k8sClient.Get(ctx, typeNamespacedName, &deployment)).To(Succeed())
+			Expect(deployment.ResourceVersion).To(Equal(resourceVersion))
+			Expect(deployment.Annotations).To(HaveKeyWithValue("team.example.com/owner", "platform"))
+			Expect(deployment.Annotations).To(HaveKey(appliedConfigAnnotation))
+
+			By("Changing the resource")
+			Expect(k8sClient.Get(ctx, typeNamespacedName, myappresource)).To(Succeed())
+			myappresource.Spec.ReplicaCount = 3
+			Expect(k8sClient.Update(ctx, myappresource)).To(Succeed())
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(k8sClient.Get(ctx, typeNamespacedName, &deployment)).To(Succeed())
+			Expect(*deployment.Spec.Replicas).To(BeEquivalentTo(3))
+			Expect(deployment.Annotations).To(HaveKeyWithValue("team.example.com/owner", "platform"))
+			Expect(deployment.ManagedFields).To(ContainElement(HaveField("Manager", fieldManager)))
+		})
+		It("should refuse to take over objects it does not own", func() {
+			By("Creating a Deployment with the same name outside of the controller")
+			recorder := record.NewFakeRecorder(100)
+			controllerReconciler := &MyAppResourceReconciler{
+				Client:   k8sClient,
+				Scheme:   k8sClient.Scheme(),
+				Recorder: recorder,
+			}
+
+			foreign := appsv1.Deployment{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      resourceName,
+					Namespace: "default",
+					Labels:    map[string]string{render.InstanceLabel: resourceName},
+				},
+				Spec: appsv1.DeploymentSpec{
+					Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "myappresource"}},
+					Template: corev1.PodTemplateSpec{
+						ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "myappresource"}},
+						Spec: corev1.PodSpec{
+							Containers: []corev1.Container{{Name: "podinfo", Image: "nginx:1.25"}},
+						},
+					},
+				},
+			}
+			Expect(k8sClient.Create(ctx, &foreign)).To(Succeed())
+
+			result, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.RequeueAfter).To(Equal(conflictRequeueAfter))
+
+			deployment := appsv1.Deployment{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, &deployment)).To(Succeed())
+			Expect(deployment.Spec.Template.Spec.Containers[0].Image).To(Equal("nginx:1.25"))
+			Expect(deployment.OwnerReferences).To(BeEmpty())
+
+			Expect(k8sClient.Get(ctx, typeNamespacedName, myappresource)).To(Succeed())
+			condition := meta.FindStatusCondition(myappresource.Status.Conditions, "ResourceConflict")
+			Expect(condition).NotTo(BeNil())
+			Expect(condition.Status).To(Equal(metav1.ConditionTrue))
+			Expect(condition.Reason).To(Equal("NotOwned"))
+			Expect(recordedEvents(recorder)).To(ContainElement(ContainSubstring("Warning ResourceConflict Deployment " + resourceName)))
+
+			By("Adopting the labelled Deployment once allowed")
+			myappresource.Spec.AdoptExisting = true
+			Expect(k8sClient.Update(ctx, myappresource)).To(Succeed())
+			Expect(k8sClient.Get(ctx, typeNamespacedName, &deployment)).To(Succeed())
+			deployment.Labels[adoptLabel] = resourceName
+			Expect(k8sClient.Update(ctx, &deployment)).To(Succeed())
+
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(k8sClient.Get(ctx, typeNamespacedName, &deployment)).To(Succeed())
+			Expect(metav1.IsControlledBy(&deployment, myappresource)).To(BeTrue())
+			Expect(deployment.Spec.Template.Spec.Containers).To(ContainElement(
+				HaveField("Image", "ghcr.io/stefanprodan/podinfo:latest")))
+			Expect(recordedEvents(recorder)).To(ContainElement(ContainSubstring("Normal Adopted Adopted existing Deployment")))
+			// The immutable selector of the existing Deployment is kept
+			Expect(deployment.Spec.Selector.MatchLabels).To(Equal(map[string]string{"app": "myappresource"}))
+
+			Expect(k8sClient.Get(ctx, typeNamespacedName, myappresource)).To(Succeed())
+			Expect(meta.IsStatusConditionFalse(myappresource.Status.Conditions, "ResourceConflict")).To(BeTrue())
+		})
+		It("should create renamed objects before deleting the old ones", func() {
+			By("Reconciling the resource under its own name")
+			controllerReconciler := &MyAppResourceReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+
+			Expect(k8sClient.Get(ctx, typeNamespacedName, myappresource)).To(Succeed())
+			myappresource.Spec.ServiceAccount = myv1alpha1.ServiceAccount{Create: true}
+			Expect(k8sClient.Update(ctx, myappresource)).To(Succeed())
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			By("Renaming the objects through spec.nameOverride")
+			Expect(k8sClient.Get(ctx, typeNamespacedName, myappresource)).To(Succeed())
+			myappresource.Spec.NameOverride = "canary"
+			Expect(k8sClient.Update(ctx, myappresource)).To(Succeed())
+			renamed := types.NamespacedName{Name: resourceName + "-canary", Namespace: "default"}
+
+			result, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.RequeueAfter).To(Equal(renameRequeueAfter))
+
+			service := corev1.Service{}
+			Expect(k8sClient.Get(ctx, renamed, &service)).To(Succeed())
+			serviceAccount := corev1.ServiceAccount{}
+			Expect(k8sClient.Get(ctx, renamed, &serviceAccount)).To(Succeed())
+
+			By("Keeping the objects the old pods use while the old Deployment serves")
+			Expect(k8sClient.Get(ctx, typeNamespacedName, &service)).To(Succeed())
+			Expect(k8sClient.Get(ctx, typeNamespacedName, &serviceAccount)).To(Succeed())
+			deployment := appsv1.Deployment{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, &deployment)).To(Succeed())
+			Expect(deployment.Spec.Template.Spec.ServiceAccountName).To(Equal(resourceName))
+			Expect(k8sClient.Get(ctx, renamed, &deployment)).To(Succeed())
+
+			By("Deleting the old Deployment once the new one has rolled out")
+			deployment.Status = appsv1.DeploymentStatus{
+				ObservedGeneration: deployment.Generation,
+				Replicas:           1,
+				UpdatedReplicas:    1,
+				AvailableReplicas:  1,
+			}
+			Expect(k8sClient.Status().Update(ctx, &deployment)).To(Succeed())
+
+			result, err = controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.RequeueAfter).To(BeZero())
+			Expect(errors.IsNotFound(k8sClient.Get(ctx, typeNamespacedName, &deployment))).To(BeTrue())
+			Expect(errors.IsNotFound(k8sClient.Get(ctx, typeNamespacedName, &serviceAccount))).To(BeTrue())
+			Expect(errors.IsNotFound(k8sClient.Get(ctx, typeNamespacedName, &service))).To(BeTrue())
+		})
+		It("should stop the pods and keep the claims with the Retain policy", func() {
+			By("Reconciling a resource retaining its data")
+			recorder := record.NewFakeRecorder(100)
+			controllerReconciler := &MyAppResourceReconciler{
+				Client:   k8sClient,
+				Scheme:   k8sClient.Scheme(),
+				Recorder: recorder,
+			}
+
+			Expect(k8sClient.Get(ctx, typeNamespacedName, myappresource)).To(Succeed())
+			myappresource.Spec.NameOverride = "retained"
+			myappresource.Spec.DeletionPolicy = myv1alpha1.DeletionPolicyRetain
+			myappresource.Spec.Persistence.Enabled = true
+			myappresource.Spec.Redis.SnapshotClaimName = "redis-snapshots"
+			Expect(k8sClient.Update(ctx, myappresource)).To(Succeed())
+			renamed := types.NamespacedName{Name: resourceName + "-retained", Namespace: "default"}
+
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(k8sClient.Get(ctx, typeNamespacedName, myappresource)).To(Succeed())
+			Expect(myappresource.Finalizers).To(ContainElement(teardownFinalizer))
+			deployment := appsv1.Deployment{}
+			Expect(k8sClient.Get(ctx, renamed, &deployment)).To(Succeed())
+			Expect(deployment.Spec.Template.Spec.Containers).To(ContainElement(
+				HaveField("Lifecycle.PreStop.Exec.Command", ContainElement("/snapshot/redis.rdb"))))
+
+			replicaSet := appsv1.ReplicaSet{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      renamed.Name + "-5d4f8",
+					Namespace: "default",
+					Labels:    deployment.Spec.Template.Labels,
+					OwnerReferences: []metav1.OwnerReference{
+						*metav1.NewControllerRef(&deployment, appsv1.SchemeGroupVersion.WithKind("Deployment")),
+					},
+				},
+				Spec: appsv1.ReplicaSetSpec{
+					Selector: deployment.Spec.Selector,
+					Template: deployment.Spec.Template,
+				},
+			}
+			Expect(k8sClient.Create(ctx, &replicaSet)).To(Succeed())
+			pod := corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      resourceName + "-pod",
+					Namespace: "default",
+					Labels:    deployment.Spec.Template.Labels,
+					OwnerReferences: []metav1.OwnerReference{
+						*metav1.NewControllerRef(&replicaSet, appsv1.SchemeGroupVersion.WithKind("ReplicaSet")),
+					},
+				},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{Name: "podinfo", Image: "ghcr.io/stefanprodan/podinfo:latest"}},
+				},
+			}
+			Expect(k8sClient.Create(ctx, &pod)).To(Succeed())
+			// A pod of another release sharing the labels must not be waited for
+			foreign := corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      resourceName + "-helm-pod",
+					Namespace: "default",
+					Labels:    deployment.Spec.Template.Labels,
+				},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{Name: "app", Image: "nginx:1.25"}},
+				},
+			}
+			Expect(k8sClient.Create(ctx, &foreign)).To(Succeed())
+			DeferCleanup(func() {
+				Expect(client.IgnoreNotFound(k8sClient.Delete(ctx, &foreign, client.GracePeriodSeconds(0)))).To(Succeed())
+				Expect(client.IgnoreNotFound(k8sClient.Delete(ctx, &replicaSet))).To(Succeed())
+			})
+
+			By("Deleting the resource while a pod is running")
+			Expect(k8sClient.Delete(ctx, myappresource)).To(Succeed())
+			result, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.RequeueAfter).To(Equal(teardownRequeueAfter))
+
+			Expect(k8sClient.Get(ctx, renamed, &deployment)).To(Succeed())
+			Expect(*deployment.Spec.Replicas).To(BeZero())
+			Expect(k8sClient.Get(ctx, typeNamespacedName, myappresource)).To(Succeed())
+
+			By("Releasing the claim once the pods are gone")
+			Expect(k8sClient.Delete(ctx, &pod, client.GracePeriodSeconds(0))).To(Succeed())
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			claim := corev1.PersistentVolumeClaim{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: renamed.Name + "-data", Namespace: "default"}, &claim)).To(Succeed())
+			Expect(claim.OwnerReferences).To(BeEmpty())
+			Expect(recordedEvents(recorder)).To(ContainElement(ContainSubstring("Normal Released Kept PersistentVolumeClaim " + claim.Name)))
+			Expect(errors.IsNotFound(k8sClient.Get(ctx, typeNamespacedName, myappresource))).To(BeTrue())
+		})
+		It("should record Events for the lifecycle of the children", func() {
+			By("Reconciling the created resource")
+			recorder := record.NewFakeRecorder(100)
+			controllerReconciler := &MyAppResourceReconciler{
+				Client:   k8sClient,
+				Scheme:   k8sClient.Scheme(),
+				Recorder: recorder,
+			}
+
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(recordedEvents(recorder)).To(ContainElements(
+				"Normal Created Created Service "+resourceName,
+				"Normal Created Created Deployment "+resourceName,
+			))
+
+			By("Reconciling again without changes")
+			for i := 0; i < 3; i++ {
+				_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{
+					NamespacedName: typeNamespacedName,
+				})
+				Expect(err).NotTo(HaveOccurred())
+			}
+			Expect(recordedEvents(recorder)).To(Equal([]string{"Normal Unchanged 2 of 2 objects already up to date"}))
+
+			By("Scaling the resource and finishing the rollout")
+			Expect(k8sClient.Get(ctx, typeNamespacedName, myappresource)).To(Succeed())
+			myappresource.Spec.ReplicaCount = 2
+			myappresource.Spec.UI.Message = "hello again"
+			Expect(k8sClient.Update(ctx, myappresource)).To(Succeed())
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(recordedEvents(recorder)).To(ContainElements(
+				"Normal Updated Updated Deployment "+resourceName,
+				"Normal Scaled Scaled Deployment "+resourceName+" from 1 to 2 replicas",
+				ContainSubstring("Normal RolloutStarted Rolling out Deployment "+resourceName),
+			))
+
+			deployment := appsv1.Deployment{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, &deployment)).To(Succeed())
+			deployment.Status = appsv1.DeploymentStatus{
+				ObservedGeneration: deployment.Generation,
+				Replicas:           2,
+				UpdatedReplicas:    2,
+				AvailableReplicas:  2,
+			}
+			Expect(k8sClient.Status().Update(ctx, &deployment)).To(Succeed())
+			for i := 0; i < 2; i++ {
+				_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{
+					NamespacedName: typeNamespacedName,
+				})
+				Expect(err).NotTo(HaveOccurred())
+			}
+			Expect(recordedEvents(recorder)).To(ContainElement(ContainSubstring("Normal RolloutFinished Deployment " + resourceName)))
+
+			By("Reporting invalid quantities")
+			Expect(k8sClient.Get(ctx, typeNamespacedName, myappresource)).To(Succeed())
+			myappresource.Spec.Resources.MemoryLimit = "lots"
+			Expect(k8sClient.Update(ctx, myappresource)).To(Succeed())
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).To(HaveOccurred())
+			Expect(recordedEvents(recorder)).To(ContainElement(ContainSubstring("Warning InvalidQuantity parsing memory limit")))
+		})
+		It("should count applied and skipped writes", func() {
+			By("Reconciling the created resource twice")
+			controllerReconciler := &MyAppResourceReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+			applied := testutil.ToFloat64(childWrites.WithLabelValues("Deployment", "applied"))
+			skipped := testutil.ToFloat64(childWrites.WithLabelValues("Deployment", "skipped"))
+
+			for i := 0; i < 2; i++ {
+				_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+					NamespacedName: typeNamespacedName,
+				})
+				Expect(err).NotTo(HaveOccurred())
+			}
+			Expect(testutil.ToFloat64(childWrites.WithLabelValues("Deployment", "applied"))).To(Equal(applied + 1))
+			Expect(testutil.ToFloat64(childWrites.WithLabelValues("Deployment", "skipped"))).To(Equal(skipped + 1))
+
+			By("Reverting a change made to a field the controller sets")
+			deployment := appsv1.Deployment{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, &deployment)).To(Succeed())
+			deployment.Spec.Replicas = ptr.To[int32](5)
+			Expect(k8sClient.Update(ctx, &deployment)).To(Succeed())
+
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(k8sClient.Get(ctx, typeNamespacedName, &deployment)).To(Succeed())
+			Expect(*deployment.Spec.Replicas).To(BeEquivalentTo(1))
+			Expect(testutil.ToFloat64(childWrites.WithLabelValues("Deployment", "applied"))).To(Equal(applied + 2))
+			Expect(testutil.ToFloat64(driftCorrections.WithLabelValues("Deployment"))).To(BeNumerically(">=", 1))
+		})
+		It("should not retry invalid specs and report them in a condition", func() {
+			By("Reconciling a resource with an invalid quantity")
+			controllerReconciler := &MyAppResourceReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, myappresource)).To(Succeed())
+			myappresource.Spec.Resources.CpuLimit = "fast"
+			Expect(k8sClient.Update(ctx, myappresource)).To(Succeed())
+			failures := testutil.ToFloat64(reconcileErrors.WithLabelValues(errorTerminal, "InvalidQuantity"))
+			renderFailed := testutil.ToFloat64(renderFailures.WithLabelValues("InvalidQuantity"))
+
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).To(MatchError(reconcile.TerminalError(nil)))
+			Expect(testutil.ToFloat64(reconcileErrors.WithLabelValues(errorTerminal, "InvalidQuantity"))).To(Equal(failures + 1))
+			Expect(testutil.ToFloat64(renderFailures.WithLabelValues("InvalidQuantity"))).To(Equal(renderFailed + 1))
+
+			Expect(k8sClient.Get(ctx, typeNamespacedName, myappresource)).To(Succeed())
+			condition := meta.FindStatusCondition(myappresource.Status.Conditions, conditionReconciled)
+			Expect(condition).NotTo(BeNil())
+			Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+			Expect(condition.Reason).To(Equal("InvalidQuantity"))
+
+			By("Recovering once the spec is fixed")
+			myappresource.Spec.Resources.CpuLimit = "200m"
+			Expect(k8sClient.Update(ctx, myappresource)).To(Succeed())
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(k8sClient.Get(ctx, typeNamespacedName, myappresource)).To(Succeed())
+			Expect(meta.IsStatusConditionTrue(myappresource.Status.Conditions, conditionReconciled)).To(BeTrue())
+		})
+		It("should report instances, replicas and the last success", func() {
+			By("Collecting before the resource was reconciled")
+			controllerReconciler := &MyAppResourceReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+			registry := prometheus.NewRegistry()
+			Expect(registry.Register(instanceCollector{controllerReconciler})).To(Succeed())
+			Expect(testutil.GatherAndCount(registry, "myappresource_seconds_since_last_success")).To(Equal(0))
+
+			By("Collecting after a successful reconciliation")
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(testutil.GatherAndCompare(registry, strings.NewReader(`
+# HELP myappresource_desired_replicas Number of replicas asked for by a MyAppResource.
+# TYPE myappresource_desired_replicas gauge
+myappresource_desired_replicas{name="test-resource",namespace="default"} 1
+# HELP myappresource_instances Number of MyAppResources, by namespace and redis mode.
+# TYPE myappresource_instances gauge
+myappresource_instances{namespace="default",redis_mode="enabled"} 1
+# HELP myappresource_ready_replicas Number of ready replicas of the Deployments of a MyAppResource.
+# TYPE myappresource_ready_replicas gauge
+myappresource_ready_replicas{name="test-resource",namespace="default"} 0
+`), "myappresource_desired_replicas", "myappresource_instances", "myappresource_ready_replicas")).To(Succeed())
+			Expect(testutil.GatherAndCount(registry, "myappresource_seconds_since_last_success")).To(Equal(1))
+		})
+		It("should reconcile every resource when the configuration is reloaded", func() {
+			controllerReconciler := &MyAppResourceReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+			Expect(controllerReconciler.requestsForAll(ctx, &myv1alpha1.MyAppResource{})).To(ContainElement(
+				reconcile.Request{NamespacedName: typeNamespacedName}))
+		})
+		It("should time rollouts from their start to their end", func() {
+			controllerReconciler := &MyAppResourceReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			rollouts := observations(rolloutDuration.WithLabelValues("succeeded"))
+
+			By("Marking the Deployment rolled out")
+			deployment := appsv1.Deployment{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, &deployment)).To(Succeed())
+			deployment.Status = appsv1.DeploymentStatus{
+				ObservedGeneration: deployment.Generation,
+				Replicas:           1,
+				UpdatedReplicas:    1,
+				ReadyReplicas:      1,
+				AvailableReplicas:  1,
+			}
+			Expect(k8sClient.Status().Update(ctx, &deployment)).To(Succeed())
+
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(observations(rolloutDuration.WithLabelValues("succeeded"))).To(Equal(rollouts + 1))
+		})
+		It("should ignore resources outside the watched namespaces", func() {
+			By("Reconciling with the resource's namespace not watched")
+			controllerReconciler := &MyAppResourceReconciler{
+				Client:     k8sClient,
+				Scheme:     k8sClient.Scheme(),
+				Namespaces: []string{"team-a"},
+			}
+
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(k8sClient.Get(ctx, typeNamespacedName, &appsv1.Deployment{})).NotTo(Succeed())
+			Expect(k8sClient.Get(ctx, typeNamespacedName, myappresource)).To(Succeed())
+			Expect(myappresource.Finalizers).To(BeEmpty())
+		})
+		It("should only reconcile resources of its shard and hand drained ones over", func() {
+			By("Reconciling a resource assigned to another shard")
+			controllerReconciler := &MyAppResourceReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+				Shard:  "shard-0",
+			}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, myappresource)).To(Succeed())
+			myappresource.Labels = map[string]string{sharding.ShardLabel: "shard-1"}
+			Expect(k8sClient.Update(ctx, myappresource)).To(Succeed())
+
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(k8sClient.Get(ctx, typeNamespacedName, &appsv1.Deployment{})).NotTo(Succeed())
+
+			By("Letting go of a resource being drained")
+			Expect(k8sClient.Get(ctx, typeNamespacedName, myappresource)).To(Succeed())
+			myappresource.Labels = map[string]string{sharding.ShardLabel: "shard-0", sharding.DrainLabel: "true"}
+			Expect(k8sClient.Update(ctx, myappresource)).To(Succeed())
+
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(k8sClient.Get(ctx, typeNamespacedName, myappresource)).To(Succeed())
+			Expect(myappresource.Labels).NotTo(HaveKey(sharding.ShardLabel))
+			Expect(myappresource.Labels).NotTo(HaveKey(sharding.DrainLabel))
+			Expect(k8sClient.Get(ctx, typeNamespacedName, &appsv1.Deployment{})).NotTo(Succeed())
+		})
+		It("should trace the reconciliation and its steps", func() {
+			exporter := tracetest.NewInMemoryExporter()
+			previous := otel.GetTracerProvider()
+			otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+			defer otel.SetTracerProvider(previous)
+
+			controllerReconciler := &MyAppResourceReconciler{
+				Client: tracing.NewClient(k8sClient),
+				Scheme: k8sClient.Scheme(),
+			}
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			spans := map[string]tracetest.SpanStub{}
+			for _, span := range exporter.GetSpans() {
+				if _, ok := spans[span.Name]; !ok {
+					spans[span.Name] = span
+				}
+			}
+			Expect(spans).To(HaveKey("Reconcile"))
+			root := spans["Reconcile"]
+			Expect(root.Attributes).To(ContainElements(
+				attribute.String("myappresource.namespace", "default"),
+				attribute.String("myappresource.name", resourceName),
+				attribute.Int64("myappresource.generation", 1),
+			))
+			for _, name := range []string{"Get MyAppResource", "Render", "ApplyOverrides", "Apply Deployment", "Prune", "UpdateStatus MyAppResource"} {
+				Expect(spans).To(HaveKey(name))
+				Expect(spans[name].Parent.SpanID()).To(Equal(root.SpanContext.SpanID()), name)
+			}
+			Expect(spans["Get Deployment"].Parent.SpanID()).To(Equal(spans["Apply Deployment"].SpanContext.SpanID()))
+			Expect(spans["Patch Deployment"].Parent.SpanID()).To(Equal(spans["Apply Deployment"].SpanContext.SpanID()))
+			Expect(spans["Apply Deployment"].Attributes).To(ContainElement(attribute.String("apply.result", "created")))
+		})
+	})
+})
+
+// observations returns the number of values a histogram observed.
+func observations(h prometheus.Observer) uint64 {
+	m := dto.Metric{}
+	Expect(h.(prometheus.Metric).Write(&m)).To(Succeed())
+	return m.GetHistogram().GetSampleCount()
+}
+
+var _ = Describe("Redis mode", func() {
+	It("should follow the profile rather than spec.redis.enabled", func() {
+		mar := &myv1alpha1.MyAppResource{}
+		Expect(redisMode(mar)).To(Equal("disabled"))
+		mar.Spec.Profile = render.ProfilePodinfoRedis
+		Expect(redisMode(mar)).To(Equal("enabled"))
+		mar.Spec.Redis.SnapshotClaimName = "redis-snapshots"
+		Expect(redisMode(mar)).To(Equal("snapshot"))
+		mar.Spec.Profile = render.ProfilePodinfo
+		mar.Spec.Redis.Enabled = true
+		Expect(redisMode(mar)).To(Equal("snapshot"))
+	})
+})
+
+var _ = Describe("Reconcile error classification", func() {
+	It("should tell transient, terminal and ownership errors apart", func() {
+		gr := schema.GroupResource{Group: "apps", Resource: "deployments"}
+		for _, c := range []struct {
+			err           error
+			class, reason string
+		}{
+			{errors.NewConflict(gr, "test-resource", nil), errorTransient, "Conflict"},
+			{errors.NewTooManyRequests("slow down", 1), errorTransient, "Throttled"},
+			{errors.NewServerTimeout(gr, "patch", 1), errorTransient, "Timeout"},
+			{errors.NewServiceUnavailable("down"), errorTransient, "ServiceUnavailable"},
+			{errors.NewInvalid(schema.GroupKind{Group: "apps", Kind: "Deployment"}, "test-resource", nil), errorTerminal, "Rejected"},
+			{&invalidSpecError{reason: "InvalidQuantity", err: resource.ErrFormatWrong}, errorTerminal, "InvalidQuantity"},
+			{&ownershipConflict{kind: "Deployment", name: "test-resource", reason: "NotOwned"}, errorOwnership, "NotOwned"},
+		} {
+			class, reason := classifyError(c.err)
+			Expect(class).To(Equal(c.class), c.err.Error())
+			Expect(reason).To(Equal(c.reason), c.err.Error())
+		}
+	})
+})
+
+var _ = Describe("Child watch predicate", func() {
+	It("should ignore updates of the status and bookkeeping fields", func() {
+		old := &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-resource", ResourceVersion: "1"},
+			Spec:       corev1.ServiceSpec{Ports: []corev1.ServicePort{{Name: "http", Port: 9898}}},
+		}
+		updated := old.DeepCopy()
+		updated.ResourceVersion = "2"
+		updated.Status.LoadBalancer.Ingress = []corev1.LoadBalancerIngress{{IP: "10.0.0.1"}}
+		Expect(childChanged.Update(event.UpdateEvent{ObjectOld: old, ObjectNew: updated})).To(BeFalse())
+
+		updated.Labels = map[string]string{"team": "platform"}
+		Expect(childChanged.Update(event.UpdateEvent{ObjectOld: old, ObjectNew: updated})).To(BeTrue())
+	})
+
+	It("should pass the rollout progress of Deployments", func() {
+		old := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "test-resource"}}
+		updated := old.DeepCopy()
+		updated.Status.Conditions = []appsv1.DeploymentCondition{{Type: appsv1.DeploymentAvailable, Status: corev1.ConditionTrue}}
+		Expect(childChanged.Update(event.UpdateEvent{ObjectOld: old, ObjectNew: updated})).To(BeFalse())
+
+		updated.Status.AvailableReplicas = 1
+		Expect(childChanged.Update(event.UpdateEvent{ObjectOld: old, ObjectNew: updated})).To(BeTrue())
 	})
 })
@@ -0,0 +1,85 @@
+/*
+Copyright 2024 shiliohstuart6.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	myv1alpha1 "github.com/shilohstuart6/Custom-Controller.git/api/v1alpha1"
+	"github.com/shilohstuart6/Custom-Controller.git/pkg/render"
+)
+
+const (
+	// adoptLabel marks an existing object as free to be adopted by the
+	// MyAppResource named in its value, when that resource sets
+	// spec.adoptExisting.
+	adoptLabel = "my.api.group/adopt"
+
+	// conditionResourceConflict is true while a rendered object exists that
+	// the controller may not take over.
+	conditionResourceConflict = "ResourceConflict"
+)
+
+// ownershipConflict reports an existing object the controller refuses to
+// take over.
+type ownershipConflict struct {
+	kind   string
+	name   string
+	reason string
+	detail string
+}
+
+func (e *ownershipConflict) Error() string {
+	return fmt.Sprintf("%s %s %s", e.kind, e.name, e.detail)
+}
+
+// checkOwnership returns an ownershipConflict unless the existing object is
+// controlled by the custom resource, or may be adopted by it.
+func checkOwnership(mar *myv1alpha1.MyAppResource, kind string, live client.Object) error {
+	if metav1.IsControlledBy(live, mar) {
+		return nil
+	}
+	if owner := metav1.GetControllerOf(live); owner != nil {
+		return &ownershipConflict{
+			kind:   kind,
+			name:   live.GetName(),
+			reason: "ControlledByOther",
+			detail: fmt.Sprintf("already exists and is controlled by %s %s", owner.Kind, owner.Name),
+		}
+	}
+	if !mar.Spec.AdoptExisting {
+		return &ownershipConflict{
+			kind:   kind,
+			name:   live.GetName(),
+			reason: "NotOwned",
+			detail: "already exists and is not controlled by this MyAppResource; set spec.adoptExisting and label it " +
+				adoptLabel + "=" + render.Instance(*mar) + " to take it over",
+		}
+	}
+	if live.GetLabels()[adoptLabel] != render.Instance(*mar) {
+		return &ownershipConflict{
+			kind:   kind,
+			name:   live.GetName(),
+			reason: "AdoptionNotAllowed",
+			detail: "already exists without the label " + adoptLabel + "=" + render.Instance(*mar) + " allowing its adoption",
+		}
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+/*
+Copyright 2024 shiliohstuart6.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"reflect"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+// childChanged filters out the updates of child objects that cannot change
+// what the reconciler does: those only touching their resource version,
+// managed fields or status. The status of Deployments is kept as far as it
+// tells how their rollout progresses, which renames and rollout Events
+// wait for.
+var childChanged = predicate.Funcs{
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		if e.ObjectOld == nil || e.ObjectNew == nil {
+			return true
+		}
+		old, err := relevantFields(e.ObjectOld)
+		if err != nil {
+			return true
+		}
+		updated, err := relevantFields(e.ObjectNew)
+		if err != nil {
+			return true
+		}
+		return !reflect.DeepEqual(old, updated)
+	},
+}
+
+// relevantFields returns the fields of a child object the reconciler reads.
+func relevantFields(obj client.Object) (map[string]interface{}, error) {
+	u, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return nil, err
+	}
+	if metadata, ok := u["metadata"].(map[string]interface{}); ok {
+		delete(metadata, "resourceVersion")
+		delete(metadata, "managedFields")
+	}
+	delete(u, "status")
+	if d, ok := obj.(*appsv1.Deployment); ok {
+		u["status"] = map[string]interface{}{
+			"observedGeneration": d.Status.ObservedGeneration,
+			"replicas":           d.Status.Replicas,
+			"updatedReplicas":    d.Status.UpdatedReplicas,
+			"availableReplicas":  d.Status.AvailableReplicas,
+			"failed":             deploymentRolloutFailed(d),
+		}
+	}
+	return u, nil
+}
@@ -0,0 +1,152 @@
+/*
+Copyright 2024 shiliohstuart6.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package health implements the checks served by the /healthz and /readyz
+// endpoints of the manager. Each check is registered under its own name so
+// that /readyz?verbose and /healthz?verbose show which one failed.
+package health
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+)
+
+// checkTimeout bounds the checks waiting on the cache or the API server, so
+// that a probe gets an answer before the kubelet gives up on it.
+const checkTimeout = 5 * time.Second
+
+// CacheSync fails until the informers of the cache have started and synced,
+// so that the manager does not report ready while it still reconciles from
+// an empty cache.
+func CacheSync(c cache.Cache) healthz.Checker {
+	return func(req *http.Request) error {
+		ctx, cancel := context.WithTimeout(req.Context(), checkTimeout)
+		defer cancel()
+		if !c.WaitForCacheSync(ctx) {
+			return errors.New("informer caches are not synced")
+		}
+		return nil
+	}
+}
+
+// WebhookCert fails when the serving certificate of the webhook server,
+// read from dir, cannot be parsed or is not valid now. The file is read on
+// every check as the webhook server reloads it when it is rotated.
+func WebhookCert(dir, name string) healthz.Checker {
+	path := filepath.Join(dir, name)
+	return func(_ *http.Request) error {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("reading the webhook certificate: %w", err)
+		}
+		block, _ := pem.Decode(data)
+		if block == nil || block.Type != "CERTIFICATE" {
+			return fmt.Errorf("%s holds no PEM certificate", path)
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return fmt.Errorf("parsing the webhook certificate: %w", err)
+		}
+		now := time.Now()
+		if now.Before(cert.NotBefore) {
+			return fmt.Errorf("webhook certificate is not valid before %s", cert.NotBefore.Format(time.RFC3339))
+		}
+		if now.After(cert.NotAfter) {
+			return fmt.Errorf("webhook certificate expired at %s", cert.NotAfter.Format(time.RFC3339))
+		}
+		return nil
+	}
+}
+
+// APIServer fails when the API server cannot be reached or does not report
+// itself ready.
+func APIServer(c rest.Interface) healthz.Checker {
+	return func(req *http.Request) error {
+		ctx, cancel := context.WithTimeout(req.Context(), checkTimeout)
+		defer cancel()
+		if err := c.Get().AbsPath("/readyz").Do(ctx).Error(); err != nil {
+			return fmt.Errorf("API server is not ready: %w", err)
+		}
+		return nil
+	}
+}
+
+// LeaderElection fails when this replica has been elected but the election
+// Lease is not held by it anymore or has not been renewed within its
+// duration, which means the leader election is wedged while the controllers
+// keep running. Replicas waiting to be elected always pass. It reads the
+// Lease from the API server, so it is a readiness check: an unreachable API
+// server must not get the leader restarted.
+//
+// The manager holds the Lease under its hostname followed by "_" and a
+// random suffix, so the holder is recognized by the hostname alone.
+type LeaderElection struct {
+	// Reader reads the Lease. It should not be backed by the cache of the
+	// manager, which does not watch Leases.
+	Reader client.Reader
+	// Elected is closed once this replica is elected, see
+	// manager.Manager.Elected.
+	Elected <-chan struct{}
+	// Namespace and Name of the Lease.
+	Namespace string
+	Name      string
+	// Identity is the hostname of this replica.
+	Identity string
+}
+
+// Check implements healthz.Checker.
+func (l *LeaderElection) Check(req *http.Request) error {
+	select {
+	case <-l.Elected:
+	default:
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), checkTimeout)
+	defer cancel()
+	lease := coordinationv1.Lease{}
+	if err := l.Reader.Get(ctx, client.ObjectKey{Namespace: l.Namespace, Name: l.Name}, &lease); err != nil {
+		return fmt.Errorf("reading the leader election Lease: %w", err)
+	}
+	holder := ""
+	if lease.Spec.HolderIdentity != nil {
+		holder = *lease.Spec.HolderIdentity
+	}
+	if !strings.HasPrefix(holder, l.Identity+"_") {
+		return fmt.Errorf("leader election Lease is held by %q", holder)
+	}
+	if lease.Spec.RenewTime == nil || lease.Spec.LeaseDurationSeconds == nil {
+		return errors.New("leader election Lease has never been renewed")
+	}
+	expiry := lease.Spec.RenewTime.Add(time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second)
+	if time.Now().After(expiry) {
+		return fmt.Errorf("leader election Lease expired at %s", expiry.Format(time.RFC3339))
+	}
+	return nil
+}
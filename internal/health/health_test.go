@@ -0,0 +1,203 @@
+/*
+Copyright 2024 shiliohstuart6.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package health
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/discovery"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// syncedCache is a cache reporting a fixed sync state.
+type syncedCache struct {
+	cache.Cache
+	synced bool
+}
+
+func (c syncedCache) WaitForCacheSync(context.Context) bool {
+	return c.synced
+}
+
+func probe() *http.Request {
+	return httptest.NewRequest(http.MethodGet, "/readyz", nil)
+}
+
+// writeCert writes a self-signed certificate valid from notBefore to
+// notAfter as dir/tls.crt.
+func writeCert(dir string, notBefore, notAfter time.Time) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).NotTo(HaveOccurred())
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "webhook-service.system.svc"},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	Expect(err).NotTo(HaveOccurred())
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	Expect(os.WriteFile(filepath.Join(dir, "tls.crt"), data, 0o600)).To(Succeed())
+}
+
+var _ = Describe("Health checks", func() {
+	Context("CacheSync", func() {
+		It("should pass once the caches are synced", func() {
+			Expect(CacheSync(syncedCache{synced: true})(probe())).To(Succeed())
+		})
+
+		It("should fail while the caches are not synced", func() {
+			Expect(CacheSync(syncedCache{})(probe())).To(MatchError(ContainSubstring("not synced")))
+		})
+	})
+
+	Context("WebhookCert", func() {
+		var dir string
+
+		BeforeEach(func() {
+			dir = GinkgoT().TempDir()
+		})
+
+		It("should pass while the certificate is valid", func() {
+			writeCert(dir, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+			Expect(WebhookCert(dir, "tls.crt")(probe())).To(Succeed())
+		})
+
+		It("should fail once the certificate expired", func() {
+			writeCert(dir, time.Now().Add(-2*time.Hour), time.Now().Add(-time.Hour))
+			Expect(WebhookCert(dir, "tls.crt")(probe())).To(MatchError(ContainSubstring("expired")))
+		})
+
+		It("should fail before the certificate is valid", func() {
+			writeCert(dir, time.Now().Add(time.Hour), time.Now().Add(2*time.Hour))
+			Expect(WebhookCert(dir, "tls.crt")(probe())).To(MatchError(ContainSubstring("not valid before")))
+		})
+
+		It("should fail without a certificate", func() {
+			Expect(WebhookCert(dir, "tls.crt")(probe())).To(HaveOccurred())
+			Expect(os.WriteFile(filepath.Join(dir, "tls.crt"), []byte("garbage"), 0o600)).To(Succeed())
+			Expect(WebhookCert(dir, "tls.crt")(probe())).To(MatchError(ContainSubstring("no PEM certificate")))
+		})
+	})
+
+	Context("APIServer", func() {
+		var status int
+		var server *httptest.Server
+
+		BeforeEach(func() {
+			status = http.StatusOK
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/readyz" {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				w.WriteHeader(status)
+			}))
+			DeferCleanup(server.Close)
+		})
+
+		check := func() error {
+			dc, err := discovery.NewDiscoveryClientForConfig(&rest.Config{Host: server.URL})
+			Expect(err).NotTo(HaveOccurred())
+			return APIServer(dc.RESTClient())(probe())
+		}
+
+		It("should pass while the API server is ready", func() {
+			Expect(check()).To(Succeed())
+		})
+
+		It("should fail while the API server is not ready", func() {
+			status = http.StatusInternalServerError
+			Expect(check()).To(MatchError(ContainSubstring("API server is not ready")))
+		})
+
+		It("should fail when the API server cannot be reached", func() {
+			server.Close()
+			Expect(check()).To(MatchError(ContainSubstring("API server is not ready")))
+		})
+	})
+
+	Context("LeaderElection", func() {
+		var scheme *runtime.Scheme
+		var elected chan struct{}
+
+		BeforeEach(func() {
+			scheme = runtime.NewScheme()
+			Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+			elected = make(chan struct{})
+		})
+
+		check := func(holder string, renewed time.Time) error {
+			lease := coordinationv1.Lease{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "system", Name: "a921a039.api.group"},
+				Spec: coordinationv1.LeaseSpec{
+					HolderIdentity:       ptr.To(holder),
+					LeaseDurationSeconds: ptr.To(int32(15)),
+					RenewTime:            ptr.To(metav1.NewMicroTime(renewed)),
+				},
+			}
+			l := LeaderElection{
+				Reader:    fake.NewClientBuilder().WithScheme(scheme).WithObjects(&lease).Build(),
+				Elected:   elected,
+				Namespace: "system",
+				Name:      "a921a039.api.group",
+				Identity:  "manager-0",
+			}
+			return l.Check(probe())
+		}
+
+		It("should pass while waiting to be elected", func() {
+			Expect(check("manager-1_8d3f", time.Now().Add(-time.Hour))).To(Succeed())
+		})
+
+		It("should pass while the leader renews the Lease", func() {
+			close(elected)
+			Expect(check("manager-0_5a1c", time.Now().Add(-5*time.Second))).To(Succeed())
+		})
+
+		It("should fail once the Lease is not renewed", func() {
+			close(elected)
+			Expect(check("manager-0_5a1c", time.Now().Add(-time.Minute))).To(MatchError(ContainSubstring("expired")))
+		})
+
+		It("should fail once another replica holds the Lease", func() {
+			close(elected)
+			Expect(check("manager-01_5a1c", time.Now())).To(MatchError(ContainSubstring(`held by "manager-01_5a1c"`)))
+		})
+	})
+})
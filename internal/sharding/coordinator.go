@@ -0,0 +1,344 @@
+/*
+Copyright 2024 shiliohstuart6.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sharding
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/rest"
+	toolscache "k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	myv1alpha1 "github.com/shilohstuart6/Custom-Controller.git/api/v1alpha1"
+)
+
+// syncInterval is how often the coordinator checks for shards whose Lease
+// expired, which no watch event tells.
+const syncInterval = 5 * time.Second
+
+// assignmentFailures counts the MyAppResources the coordinator failed to
+// label.
+var assignmentFailures = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "myappresource_shard_assignment_failures_total",
+	Help: "Number of failed changes of the shard labels of MyAppResources.",
+})
+
+func init() {
+	metrics.Registry.MustRegister(assignmentFailures)
+}
+
+// shardsChanged is queued, instead of the key of a MyAppResource, to check
+// whether the live shards changed.
+type shardsChanged struct{}
+
+// NewCache returns the cache the coordinator reads from. It holds the
+// metadata, without the managed fields, of the MyAppResources in namespaces,
+// or in all namespaces if empty, and the shard Leases in leaseNamespace.
+func NewCache(config *rest.Config, scheme *runtime.Scheme, mapper meta.RESTMapper,
+	namespaces []string, leaseNamespace string) (cache.Cache, error) {
+	isLease, err := labels.NewRequirement(LeaseLabel, selection.Exists, nil)
+	if err != nil {
+		return nil, err
+	}
+	opts := cache.Options{
+		Scheme:           scheme,
+		Mapper:           mapper,
+		DefaultTransform: stripManagedFields,
+		ByObject: map[client.Object]cache.ByObject{
+			&coordinationv1.Lease{}: {
+				Namespaces: map[string]cache.Config{leaseNamespace: {}},
+				Label:      labels.NewSelector().Add(*isLease),
+			},
+		},
+	}
+	if len(namespaces) > 0 {
+		opts.DefaultNamespaces = map[string]cache.Config{}
+		for _, ns := range namespaces {
+			opts.DefaultNamespaces[ns] = cache.Config{}
+		}
+	}
+	return cache.New(config, opts)
+}
+
+// stripManagedFields drops the managed fields, which are as large as the
+// rest of the metadata, from the cached objects.
+func stripManagedFields(obj interface{}) (interface{}, error) {
+	if o, ok := obj.(metav1.Object); ok {
+		o.SetManagedFields(nil)
+	}
+	return obj, nil
+}
+
+// Coordinator assigns the MyAppResources to the live shards. Only the leader
+// runs it. It assigns a MyAppResource when it changes, and all of them when
+// the live shards change.
+type Coordinator struct {
+	// Client patches the labels of the MyAppResources.
+	Client client.Client
+	// Cache holds the metadata of the MyAppResources and the shard Leases,
+	// see NewCache. It cannot be the cache of the manager, which only holds
+	// the MyAppResources of its own shard. The coordinator starts it, so
+	// that only the leader watches every MyAppResource.
+	Cache cache.Cache
+	// Namespace of the shard Leases.
+	Namespace string
+
+	queue workqueue.RateLimitingInterface
+	// shards are the live shards every MyAppResource was last assigned to,
+	// and ring the ring they form.
+	shards []string
+	ring   *ring
+}
+
+// Start assigns the MyAppResources until the context is done.
+func (c *Coordinator) Start(ctx context.Context) error {
+	c.queue = workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+	defer c.queue.ShutDown()
+
+	objects, err := c.Cache.GetInformer(ctx, objectMetadata())
+	if err != nil {
+		return err
+	}
+	if _, err := objects.AddEventHandler(toolscache.ResourceEventHandlerFuncs{
+		AddFunc:    c.enqueueObject,
+		UpdateFunc: func(_, obj interface{}) { c.enqueueObject(obj) },
+	}); err != nil {
+		return err
+	}
+	leases, err := c.Cache.GetInformer(ctx, &coordinationv1.Lease{})
+	if err != nil {
+		return err
+	}
+	if _, err := leases.AddEventHandler(toolscache.ResourceEventHandlerFuncs{
+		AddFunc:    func(interface{}) { c.queue.Add(shardsChanged{}) },
+		UpdateFunc: func(interface{}, interface{}) { c.queue.Add(shardsChanged{}) },
+		DeleteFunc: func(interface{}) { c.queue.Add(shardsChanged{}) },
+	}); err != nil {
+		return err
+	}
+
+	go func() {
+		if err := c.Cache.Start(ctx); err != nil {
+			shardinglog.Error(err, "Failed to watch the MyAppResources and shard Leases")
+		}
+	}()
+	if !c.Cache.WaitForCacheSync(ctx) {
+		if ctx.Err() != nil {
+			return nil
+		}
+		return errors.New("failed to sync the cache of the shard coordinator")
+	}
+
+	go func() {
+		ticker := time.NewTicker(syncInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				c.queue.ShutDown()
+				return
+			case <-ticker.C:
+				c.queue.Add(shardsChanged{})
+			}
+		}
+	}()
+	c.queue.Add(shardsChanged{})
+	for c.processNext(ctx) {
+	}
+	return nil
+}
+
+// NeedLeaderElection runs a single coordinator.
+func (c *Coordinator) NeedLeaderElection() bool {
+	return true
+}
+
+func (c *Coordinator) enqueueObject(obj interface{}) {
+	if o, ok := obj.(client.Object); ok {
+		c.queue.Add(client.ObjectKeyFromObject(o))
+	}
+}
+
+// processNext handles the next item of the queue, retrying it with a
+// backoff if it fails. It returns false once the queue is shut down.
+func (c *Coordinator) processNext(ctx context.Context) bool {
+	item, shutdown := c.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer c.queue.Done(item)
+
+	var err error
+	if key, ok := item.(types.NamespacedName); ok {
+		err = c.assignObject(ctx, key)
+	} else {
+		err = c.sync(ctx, time.Now())
+	}
+	if err != nil {
+		shardinglog.Error(err, "Failed to assign MyAppResources to shards")
+		c.queue.AddRateLimited(item)
+		return true
+	}
+	c.queue.Forget(item)
+	return true
+}
+
+// sync moves every MyAppResource one step closer to the shard the ring
+// assigns it to, if the live shards changed since the last sync. An object
+// failing to move does not hold the others back; they are all checked again
+// at the next sync.
+func (c *Coordinator) sync(ctx context.Context, now time.Time) error {
+	shards, err := c.liveShards(ctx, now)
+	if err != nil {
+		return err
+	}
+	if c.ring != nil && slices.Equal(shards, c.shards) {
+		return nil
+	}
+	r := newRing(shards)
+	if len(shards) > 0 {
+		shardinglog.Info("Assigning MyAppResources to shards", "Shards", shards)
+	}
+
+	list := metav1.PartialObjectMetadataList{}
+	list.SetGroupVersionKind(myv1alpha1.GroupVersion.WithKind("MyAppResourceList"))
+	if err := c.Cache.List(ctx, &list); err != nil {
+		return err
+	}
+	var errs []error
+	for i := range list.Items {
+		if err := c.assign(ctx, r, shards, &list.Items[i]); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return utilerrors.NewAggregate(errs)
+	}
+	c.shards, c.ring = shards, r
+	return nil
+}
+
+// assignObject moves the MyAppResource one step closer to its shard on the
+// ring of the last sync.
+func (c *Coordinator) assignObject(ctx context.Context, key types.NamespacedName) error {
+	if c.ring == nil {
+		// The next sync assigns the object.
+		return nil
+	}
+	obj := objectMetadata()
+	if err := c.Cache.Get(ctx, key, obj); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	return c.assign(ctx, c.ring, c.shards, obj)
+}
+
+// assign moves obj one step closer to the shard r assigns it to. Without
+// live shards it is left alone.
+func (c *Coordinator) assign(ctx context.Context, r *ring, shards []string, obj *metav1.PartialObjectMetadata) error {
+	want := r.shardFor(client.ObjectKeyFromObject(obj).String())
+	if want == "" {
+		return nil
+	}
+	current := obj.Labels[ShardLabel]
+	_, draining := obj.Labels[DrainLabel]
+
+	var labels map[string]interface{}
+	switch {
+	case current == want:
+		if draining {
+			labels = map[string]interface{}{DrainLabel: nil}
+		}
+	case current == "" || !slices.Contains(shards, current):
+		// Nobody reconciles the object, it can be assigned right away.
+		labels = map[string]interface{}{ShardLabel: want, DrainLabel: nil}
+	case !draining:
+		labels = map[string]interface{}{DrainLabel: "true"}
+	default:
+		// Waiting for the current shard to let go of the object.
+	}
+	if labels == nil {
+		return nil
+	}
+	// Patches need the kind, which list items may lack.
+	obj.SetGroupVersionKind(myv1alpha1.GroupVersion.WithKind("MyAppResource"))
+	if err := c.Client.Patch(ctx, obj, labelPatch(labels)); err != nil {
+		if client.IgnoreNotFound(err) == nil {
+			return nil
+		}
+		shardinglog.Error(err, "Failed to move MyAppResource", "Name", obj.Name, "Namespace", obj.Namespace)
+		assignmentFailures.Inc()
+		return err
+	}
+	shardinglog.V(1).Info("Moving MyAppResource", "Name", obj.Name, "Namespace", obj.Namespace,
+		"From", current, "To", want)
+	return nil
+}
+
+// objectMetadata returns an empty MyAppResource of which only the metadata
+// is read.
+func objectMetadata() *metav1.PartialObjectMetadata {
+	obj := &metav1.PartialObjectMetadata{}
+	obj.SetGroupVersionKind(myv1alpha1.GroupVersion.WithKind("MyAppResource"))
+	return obj
+}
+
+// liveShards returns the sorted names of the shards whose Leases have not
+// expired.
+func (c *Coordinator) liveShards(ctx context.Context, now time.Time) ([]string, error) {
+	leases := coordinationv1.LeaseList{}
+	if err := c.Cache.List(ctx, &leases, client.InNamespace(c.Namespace), client.HasLabels{LeaseLabel}); err != nil {
+		return nil, err
+	}
+	var shards []string
+	for i := range leases.Items {
+		if alive(&leases.Items[i], now) {
+			shards = append(shards, leases.Items[i].Name)
+		}
+	}
+	slices.Sort(shards)
+	return shards, nil
+}
+
+// ReleasePatch removes the shard and drain labels, letting the coordinator
+// assign the object to another shard.
+func ReleasePatch() client.Patch {
+	return labelPatch(map[string]interface{}{ShardLabel: nil, DrainLabel: nil})
+}
+
+// labelPatch sets or, for nil values, removes labels.
+func labelPatch(labels map[string]interface{}) client.Patch {
+	data, _ := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{"labels": labels},
+	})
+	return client.RawPatch(types.MergePatchType, data)
+}
@@ -0,0 +1,91 @@
+/*
+Copyright 2024 shiliohstuart6.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package sharding spreads MyAppResources over several manager replicas.
+// Every replica, or shard, announces itself with a Lease. A coordinator,
+// running on the leader, assigns each MyAppResource to a live shard by
+// setting the shard label, and each shard only watches the MyAppResources
+// labelled with its name.
+//
+// Moving an object from a live shard to another is a handshake, so that it
+// is never reconciled by two shards at once: the coordinator sets the drain
+// label, the old shard removes both labels when it sees it, and the
+// coordinator then assigns the object to the new shard.
+package sharding
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+const (
+	// ShardLabel names the shard reconciling a MyAppResource.
+	ShardLabel = "my.api.group/shard"
+	// DrainLabel asks the shard of a MyAppResource to let go of it.
+	DrainLabel = "my.api.group/drain"
+	// LeaseLabel marks the Leases of the shards.
+	LeaseLabel = "my.api.group/shard-lease"
+)
+
+// tokensPerShard is the number of points each shard takes on the hash ring.
+// More points spread the objects more evenly.
+const tokensPerShard = 100
+
+// ring is a consistent hash ring. Adding or removing a shard only moves the
+// objects falling between its points and those of its neighbours.
+type ring struct {
+	tokens []uint64
+	shards map[uint64]string
+}
+
+func newRing(shards []string) *ring {
+	r := &ring{shards: map[uint64]string{}}
+	for _, shard := range shards {
+		for i := 0; i < tokensPerShard; i++ {
+			token := hash(fmt.Sprintf("%s-%d", shard, i))
+			// Ties go to the smaller name, whatever the order of shards.
+			if owner, ok := r.shards[token]; ok && owner < shard {
+				continue
+			} else if !ok {
+				r.tokens = append(r.tokens, token)
+			}
+			r.shards[token] = shard
+		}
+	}
+	sort.Slice(r.tokens, func(i, j int) bool { return r.tokens[i] < r.tokens[j] })
+	return r
+}
+
+// shardFor returns the shard owning key, or "" if there are no shards.
+func (r *ring) shardFor(key string) string {
+	if len(r.tokens) == 0 {
+		return ""
+	}
+	h := hash(key)
+	i := sort.Search(len(r.tokens), func(i int) bool { return r.tokens[i] >= h })
+	if i == len(r.tokens) {
+		i = 0
+	}
+	return r.shards[r.tokens[i]]
+}
+
+// hash spreads similar keys, like the names of the shards' points, evenly.
+func hash(s string) uint64 {
+	sum := sha256.Sum256([]byte(s))
+	return binary.BigEndian.Uint64(sum[:8])
+}
@@ -0,0 +1,124 @@
+/*
+Copyright 2024 shiliohstuart6.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sharding
+
+import (
+	"context"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	// leaseDuration is how long a shard is considered alive after renewing
+	// its Lease.
+	leaseDuration = 30 * time.Second
+	// renewInterval is how often a shard renews its Lease.
+	renewInterval = 10 * time.Second
+)
+
+var shardinglog = logf.Log.WithName("sharding")
+
+// Shard keeps the Lease announcing a manager replica to the coordinator.
+// Added to the manager, it renews the Lease while the manager runs and
+// deletes it when the manager stops, so that the objects of the shard are
+// moved to the others without waiting for the Lease to expire.
+type Shard struct {
+	// Client writes the Lease. Leases are read through Reader, which should
+	// not be backed by the cache of the manager.
+	Client client.Client
+	Reader client.Reader
+	// Name of the shard and its Lease, unique among the replicas.
+	Name string
+	// Namespace of the Lease, usually the namespace of the manager.
+	Namespace string
+}
+
+// Start renews the Lease until the context is done.
+func (s *Shard) Start(ctx context.Context) error {
+	ticker := time.NewTicker(renewInterval)
+	defer ticker.Stop()
+	for {
+		if err := s.renew(ctx); err != nil {
+			shardinglog.Error(err, "Failed to renew the shard Lease", "Shard", s.Name)
+		}
+		select {
+		case <-ctx.Done():
+			s.release()
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// NeedLeaderElection lets every replica announce itself.
+func (s *Shard) NeedLeaderElection() bool {
+	return false
+}
+
+func (s *Shard) renew(ctx context.Context) error {
+	now := metav1.NewMicroTime(time.Now())
+	lease := coordinationv1.Lease{}
+	err := s.Reader.Get(ctx, client.ObjectKey{Namespace: s.Namespace, Name: s.Name}, &lease)
+	if apierrors.IsNotFound(err) {
+		lease = coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      s.Name,
+				Namespace: s.Namespace,
+				Labels:    map[string]string{LeaseLabel: "true"},
+			},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       ptr.To(s.Name),
+				LeaseDurationSeconds: ptr.To(int32(leaseDuration / time.Second)),
+				AcquireTime:          &now,
+				RenewTime:            &now,
+			},
+		}
+		return s.Client.Create(ctx, &lease)
+	}
+	if err != nil {
+		return err
+	}
+	lease.Spec.HolderIdentity = ptr.To(s.Name)
+	lease.Spec.LeaseDurationSeconds = ptr.To(int32(leaseDuration / time.Second))
+	lease.Spec.RenewTime = &now
+	return s.Client.Update(ctx, &lease)
+}
+
+// release deletes the Lease. The context of the manager is done by then.
+func (s *Shard) release() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	lease := coordinationv1.Lease{ObjectMeta: metav1.ObjectMeta{Name: s.Name, Namespace: s.Namespace}}
+	if err := s.Client.Delete(ctx, &lease); client.IgnoreNotFound(err) != nil {
+		shardinglog.Error(err, "Failed to release the shard Lease", "Shard", s.Name)
+	}
+}
+
+// alive reports whether the shard owning lease renewed it recently enough.
+func alive(lease *coordinationv1.Lease, now time.Time) bool {
+	if lease.Spec.RenewTime == nil || lease.Spec.LeaseDurationSeconds == nil {
+		return false
+	}
+	expires := lease.Spec.RenewTime.Add(time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second)
+	return now.Before(expires)
+}
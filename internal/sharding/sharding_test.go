@@ -0,0 +1,251 @@
+/*
+Copyright 2024 shiliohstuart6.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sharding
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/cache/informertest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	myv1alpha1 "github.com/shilohstuart6/Custom-Controller.git/api/v1alpha1"
+)
+
+// fakeCache reads from a client and lets the tests send informer events.
+type fakeCache struct {
+	informertest.FakeInformers
+	reader client.Reader
+}
+
+func (f *fakeCache) Get(ctx context.Context, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+	return f.reader.Get(ctx, key, obj, opts...)
+}
+
+func (f *fakeCache) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	return f.reader.List(ctx, list, opts...)
+}
+
+var _ = Describe("Ring", func() {
+	keys := func(n int) []string {
+		var keys []string
+		for i := 0; i < n; i++ {
+			keys = append(keys, fmt.Sprintf("default/app-%d", i))
+		}
+		return keys
+	}
+
+	It("Should spread the objects over all shards", func() {
+		r := newRing([]string{"shard-0", "shard-1", "shard-2"})
+		counts := map[string]int{}
+		for _, key := range keys(3000) {
+			counts[r.shardFor(key)]++
+		}
+		Expect(counts).To(HaveLen(3))
+		for _, n := range counts {
+			Expect(n).To(BeNumerically("~", 1000, 300))
+		}
+	})
+
+	It("Should not depend on the order of the shards", func() {
+		a := newRing([]string{"shard-0", "shard-1", "shard-2"})
+		b := newRing([]string{"shard-2", "shard-0", "shard-1"})
+		for _, key := range keys(500) {
+			Expect(a.shardFor(key)).To(Equal(b.shardFor(key)))
+		}
+	})
+
+	It("Should only move the objects of a shard leaving", func() {
+		before := newRing([]string{"shard-0", "shard-1", "shard-2"})
+		after := newRing([]string{"shard-0", "shard-2"})
+		for _, key := range keys(500) {
+			if owner := before.shardFor(key); owner != "shard-1" {
+				Expect(after.shardFor(key)).To(Equal(owner))
+			}
+		}
+	})
+
+	It("Should assign nothing without shards", func() {
+		Expect(newRing(nil).shardFor("default/app")).To(BeEmpty())
+	})
+})
+
+var _ = Describe("Coordinator", func() {
+	const namespace = "custom-controller-system"
+
+	ctx := context.Background()
+	now := time.Now()
+
+	var (
+		c           client.Client
+		informers   *fakeCache
+		coordinator *Coordinator
+	)
+
+	lease := func(name string, renewed time.Time) *coordinationv1.Lease {
+		return &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: map[string]string{LeaseLabel: "true"}},
+			Spec: coordinationv1.LeaseSpec{
+				LeaseDurationSeconds: ptr.To[int32](30),
+				RenewTime:            &metav1.MicroTime{Time: renewed},
+			},
+		}
+	}
+	app := func(name string, labels map[string]string) *myv1alpha1.MyAppResource {
+		return &myv1alpha1.MyAppResource{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Labels: labels}}
+	}
+	labelsOf := func(name string) map[string]string {
+		obj := myv1alpha1.MyAppResource{}
+		Expect(c.Get(ctx, client.ObjectKey{Namespace: "default", Name: name}, &obj)).To(Succeed())
+		return obj.Labels
+	}
+
+	BeforeEach(func() {
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(myv1alpha1.AddToScheme(scheme)).To(Succeed())
+		Expect(metav1.AddMetaToScheme(scheme)).To(Succeed())
+		c = fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+			lease("shard-0", now),
+			lease("shard-1", now),
+			lease("shard-gone", now.Add(-time.Minute)),
+		).Build()
+		informers = &fakeCache{FakeInformers: informertest.FakeInformers{Scheme: scheme}, reader: c}
+		coordinator = &Coordinator{Client: c, Cache: informers, Namespace: namespace}
+	})
+
+	It("Should only count shards with a recent Lease", func() {
+		Expect(coordinator.liveShards(ctx, now)).To(ConsistOf("shard-0", "shard-1"))
+	})
+
+	It("Should assign new objects and take over those of expired shards", func() {
+		Expect(c.Create(ctx, app("new", nil))).To(Succeed())
+		Expect(c.Create(ctx, app("orphan", map[string]string{ShardLabel: "shard-gone"}))).To(Succeed())
+
+		Expect(coordinator.sync(ctx, now)).To(Succeed())
+		r := newRing([]string{"shard-0", "shard-1"})
+		Expect(labelsOf("new")).To(Equal(map[string]string{ShardLabel: r.shardFor("default/new")}))
+		Expect(labelsOf("orphan")).To(Equal(map[string]string{ShardLabel: r.shardFor("default/orphan")}))
+	})
+
+	It("Should drain objects of a live shard before moving them", func() {
+		r := newRing([]string{"shard-0", "shard-1"})
+		name := ""
+		for i := 0; name == ""; i++ {
+			if candidate := fmt.Sprintf("app-%d", i); r.shardFor("default/"+candidate) == "shard-1" {
+				name = candidate
+			}
+		}
+		Expect(c.Create(ctx, app(name, map[string]string{ShardLabel: "shard-0"}))).To(Succeed())
+
+		Expect(coordinator.sync(ctx, now)).To(Succeed())
+		Expect(labelsOf(name)).To(Equal(map[string]string{ShardLabel: "shard-0", DrainLabel: "true"}))
+
+		By("Waiting for the shard to let go")
+		key := client.ObjectKey{Namespace: "default", Name: name}
+		Expect(coordinator.assignObject(ctx, key)).To(Succeed())
+		Expect(labelsOf(name)).To(HaveKeyWithValue(ShardLabel, "shard-0"))
+
+		obj := app(name, nil)
+		Expect(c.Patch(ctx, obj, ReleasePatch())).To(Succeed())
+		Expect(coordinator.assignObject(ctx, key)).To(Succeed())
+		Expect(labelsOf(name)).To(Equal(map[string]string{ShardLabel: "shard-1"}))
+	})
+
+	It("Should keep assigning objects after one fails", func() {
+		for _, name := range []string{"a", "b", "c"} {
+			Expect(c.Create(ctx, app(name, nil))).To(Succeed())
+		}
+		failures := testutil.ToFloat64(assignmentFailures)
+		coordinator.Client = interceptor.NewClient(c.(client.WithWatch), interceptor.Funcs{
+			Patch: func(ctx context.Context, c client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+				if obj.GetName() == "a" {
+					return errors.New("denied by webhook")
+				}
+				return c.Patch(ctx, obj, patch, opts...)
+			},
+		})
+
+		Expect(coordinator.sync(ctx, now)).To(MatchError(ContainSubstring("denied by webhook")))
+		Expect(labelsOf("a")).To(BeEmpty())
+		Expect(labelsOf("b")).To(HaveKey(ShardLabel))
+		Expect(labelsOf("c")).To(HaveKey(ShardLabel))
+		Expect(testutil.ToFloat64(assignmentFailures)).To(Equal(failures + 1))
+
+		By("Trying again at the next sync")
+		coordinator.Client = c
+		Expect(coordinator.sync(ctx, now)).To(Succeed())
+		Expect(labelsOf("a")).To(HaveKey(ShardLabel))
+	})
+
+	It("Should only go through every object when the live shards change", func() {
+		Expect(coordinator.sync(ctx, now)).To(Succeed())
+		Expect(c.Create(ctx, app("late", nil))).To(Succeed())
+		Expect(coordinator.sync(ctx, now)).To(Succeed())
+		Expect(labelsOf("late")).To(BeEmpty())
+
+		By("Assigning the objects again once a Lease expires")
+		Expect(coordinator.sync(ctx, now.Add(time.Minute))).To(Succeed())
+		Expect(labelsOf("late")).To(BeEmpty())
+		renewed := coordinationv1.Lease{}
+		Expect(c.Get(ctx, client.ObjectKey{Namespace: namespace, Name: "shard-1"}, &renewed)).To(Succeed())
+		renewed.Spec.RenewTime = &metav1.MicroTime{Time: now.Add(time.Minute)}
+		Expect(c.Update(ctx, &renewed)).To(Succeed())
+		Expect(coordinator.sync(ctx, now.Add(time.Minute))).To(Succeed())
+		Expect(labelsOf("late")).To(Equal(map[string]string{ShardLabel: "shard-1"}))
+	})
+
+	It("Should assign objects as they are created", func() {
+		Expect(c.Create(ctx, app("early", nil))).To(Succeed())
+		objects, err := informers.FakeInformerFor(ctx, objectMetadata())
+		Expect(err).NotTo(HaveOccurred())
+		_, err = informers.FakeInformerFor(ctx, &coordinationv1.Lease{})
+		Expect(err).NotTo(HaveOccurred())
+
+		runCtx, cancel := context.WithCancel(ctx)
+		done := make(chan error)
+		go func() { done <- coordinator.Start(runCtx) }()
+		DeferCleanup(func() {
+			cancel()
+			Eventually(done).Should(Receive(BeNil()))
+		})
+		Eventually(func() map[string]string { return labelsOf("early") }).Should(HaveKey(ShardLabel))
+
+		late := app("late", nil)
+		Expect(c.Create(ctx, late)).To(Succeed())
+		objects.Add(late)
+		Eventually(func() map[string]string { return labelsOf("late") }).Should(HaveKey(ShardLabel))
+	})
+
+	It("Should leave the objects alone without live shards", func() {
+		Expect(c.Create(ctx, app("new", nil))).To(Succeed())
+		Expect(coordinator.sync(ctx, now.Add(time.Hour))).To(Succeed())
+		Expect(labelsOf("new")).To(BeEmpty())
+	})
+})
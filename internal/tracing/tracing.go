@@ -0,0 +1,102 @@
+/*
+Copyright 2024 shiliohstuart6.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package tracing sets up OpenTelemetry tracing of the reconcile loops. The
+// controller uses the global tracer provider, which stays a no-op unless
+// Setup is given an OTLP endpoint.
+package tracing
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	myv1alpha1 "github.com/shilohstuart6/Custom-Controller.git/api/v1alpha1"
+)
+
+// tracerName is the instrumentation scope of the spans of the controller.
+const tracerName = "github.com/shilohstuart6/Custom-Controller.git"
+
+// Options configure the export of the spans.
+type Options struct {
+	// Endpoint is the URL of an OTLP/HTTP collector, like
+	// http://otel-collector:4318. Tracing is off when it is empty.
+	Endpoint string
+	// SampleRatio is the share of the reconciliations traced, from 0 to 1.
+	SampleRatio float64
+	// ServiceName names the manager in the traces.
+	ServiceName string
+}
+
+// Setup installs the global tracer provider exporting to opts.Endpoint. It
+// returns a function flushing the pending spans and stopping the export,
+// to be called when the manager stops.
+func Setup(ctx context.Context, opts Options) (func(context.Context) error, error) {
+	if opts.Endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(opts.Endpoint))
+	if err != nil {
+		return nil, err
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(opts.SampleRatio))),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", opts.ServiceName))),
+	)
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return tp.Shutdown, nil
+}
+
+// Tracer returns the tracer of the controller.
+func Tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// RequestAttributes describe the MyAppResource a reconciliation is for.
+func RequestAttributes(req ctrl.Request) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("myappresource.namespace", req.Namespace),
+		attribute.String("myappresource.name", req.Name),
+	}
+}
+
+// ResourceAttributes describe a MyAppResource read from the cluster.
+func ResourceAttributes(mar *myv1alpha1.MyAppResource) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("myappresource.namespace", mar.Namespace),
+		attribute.String("myappresource.name", mar.Name),
+		attribute.Int64("myappresource.generation", mar.Generation),
+	}
+}
+
+// End records err, if any, on the span and ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
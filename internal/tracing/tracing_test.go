@@ -0,0 +1,122 @@
+/*
+Copyright 2024 shiliohstuart6.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tracing
+
+import (
+	"context"
+	"errors"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+var _ = Describe("Tracing", func() {
+	var (
+		ctx      = context.Background()
+		exporter *tracetest.InMemoryExporter
+		previous trace.TracerProvider
+	)
+
+	BeforeEach(func() {
+		exporter = tracetest.NewInMemoryExporter()
+		previous = otel.GetTracerProvider()
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	})
+
+	AfterEach(func() {
+		otel.SetTracerProvider(previous)
+	})
+
+	spanNames := func() []string {
+		var names []string
+		for _, span := range exporter.GetSpans() {
+			names = append(names, span.Name)
+		}
+		return names
+	}
+
+	It("Should record a span per request under the caller's span", func() {
+		c := NewClient(fake.NewClientBuilder().Build())
+		ctx, parent := Tracer().Start(ctx, "Reconcile")
+
+		deployment := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"}}
+		Expect(c.Create(ctx, deployment)).To(Succeed())
+		Expect(c.Get(ctx, client.ObjectKeyFromObject(deployment), deployment)).To(Succeed())
+		Expect(c.List(ctx, &corev1.PodList{}, client.InNamespace("default"))).To(Succeed())
+		Expect(c.Status().Update(ctx, deployment)).To(Succeed())
+		parent.End()
+
+		Expect(spanNames()).To(Equal([]string{
+			"Create Deployment", "Get Deployment", "List Pod", "UpdateStatus Deployment", "Reconcile",
+		}))
+		spans := exporter.GetSpans()
+		for _, span := range spans[:4] {
+			Expect(span.Parent.SpanID()).To(Equal(parent.SpanContext().SpanID()))
+			Expect(span.SpanKind).To(Equal(trace.SpanKindClient))
+		}
+		Expect(spans[1].Attributes).To(ContainElements(
+			attribute.String("k8s.kind", "Deployment"),
+			attribute.String("k8s.namespace", "default"),
+			attribute.String("k8s.name", "app"),
+		))
+		Expect(spans[2].Attributes).To(ContainElement(attribute.String("k8s.namespace", "default")))
+	})
+
+	It("Should record failed requests", func() {
+		c := NewClient(fake.NewClientBuilder().WithInterceptorFuncs(interceptor.Funcs{
+			Delete: func(context.Context, client.WithWatch, client.Object, ...client.DeleteOption) error {
+				return errors.New("forbidden")
+			},
+		}).Build())
+
+		Expect(c.Delete(ctx, &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"}})).NotTo(Succeed())
+
+		spans := exporter.GetSpans()
+		Expect(spans).To(HaveLen(1))
+		Expect(spans[0].Name).To(Equal("Delete Pod"))
+		Expect(spans[0].Status.Code).To(Equal(codes.Error))
+		Expect(spans[0].Status.Description).To(Equal("forbidden"))
+		Expect(spans[0].Events).To(HaveLen(1))
+	})
+
+	It("Should leave the global tracer provider alone without an endpoint", func() {
+		provider := otel.GetTracerProvider()
+		shutdown, err := Setup(ctx, Options{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(otel.GetTracerProvider()).To(BeIdenticalTo(provider))
+		Expect(shutdown(ctx)).To(Succeed())
+	})
+
+	It("Should export to an OTLP endpoint when given one", func() {
+		shutdown, err := Setup(ctx, Options{Endpoint: "http://127.0.0.1:4318", SampleRatio: 1, ServiceName: "test"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(otel.GetTracerProvider()).To(BeAssignableToTypeOf(&sdktrace.TracerProvider{}))
+		Expect(shutdown(ctx)).To(Succeed())
+	})
+})
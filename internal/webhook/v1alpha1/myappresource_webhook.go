@@ -0,0 +1,229 @@
+/*
+Copyright 2024 shiliohstuart6.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	myv1alpha1 "github.com/shilohstuart6/Custom-Controller.git/api/v1alpha1"
+	"github.com/shilohstuart6/Custom-Controller.git/internal/config"
+	"github.com/shilohstuart6/Custom-Controller.git/pkg/render"
+)
+
+// log is for logging in this package.
+var myappresourcelog = logf.Log.WithName("myappresource-resource")
+
+// validationFailures counts the problems found in the MyAppResources the
+// webhook rejected, by the type of the field error.
+var validationFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "myappresource_validation_failures_total",
+	Help: "Number of field errors in rejected MyAppResources, by reason.",
+}, []string{"reason"})
+
+func init() {
+	metrics.Registry.MustRegister(validationFailures)
+}
+
+// SetupMyAppResourceWebhookWithManager registers the webhook for MyAppResource in the manager.
+func SetupMyAppResourceWebhookWithManager(mgr ctrl.Manager, opts render.Options, store *config.Store) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(&myv1alpha1.MyAppResource{}).
+		WithValidator(&MyAppResourceCustomValidator{RenderOptions: opts, Config: store, Client: mgr.GetClient()}).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/validate-my-api-group-v1alpha1-myappresource,mutating=false,failurePolicy=fail,sideEffects=None,groups=my.api.group,resources=myappresources,verbs=create;update,versions=v1alpha1,name=vmyappresource.kb.io,admissionReviewVersions=v1
+
+// MyAppResourceCustomValidator rejects MyAppResources whose rendered
+// Deployment would be invalid or would not meet the requested Pod Security
+// Standard, and those granting their pods more than the requesting user may
+// grant.
+type MyAppResourceCustomValidator struct {
+	// RenderOptions describe the cluster to the render profiles.
+	RenderOptions render.Options
+	// Config, if set, supplies the defaults and feature gates applied on
+	// top of RenderOptions.
+	Config *config.Store
+	// Client, if set, creates the SubjectAccessReviews checking that the
+	// requesting user may bind the roles and use the ServiceAccount a
+	// MyAppResource references.
+	Client client.Client
+}
+
+var _ webhook.CustomValidator = &MyAppResourceCustomValidator{}
+
+// ValidateCreate implements webhook.CustomValidator so a webhook will be registered for the type MyAppResource.
+func (v *MyAppResourceCustomValidator) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	mar, ok := obj.(*myv1alpha1.MyAppResource)
+	if !ok {
+		return nil, fmt.Errorf("expected a MyAppResource object but got %T", obj)
+	}
+	myappresourcelog.Info("Validation for MyAppResource upon creation", "name", mar.GetName())
+
+	return nil, v.validate(ctx, nil, mar)
+}
+
+// ValidateUpdate implements webhook.CustomValidator so a webhook will be registered for the type MyAppResource.
+func (v *MyAppResourceCustomValidator) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) (admission.Warnings, error) {
+	mar, ok := newObj.(*myv1alpha1.MyAppResource)
+	if !ok {
+		return nil, fmt.Errorf("expected a MyAppResource object for the newObj but got %T", newObj)
+	}
+	old, ok := oldObj.(*myv1alpha1.MyAppResource)
+	if !ok {
+		return nil, fmt.Errorf("expected a MyAppResource object for the oldObj but got %T", oldObj)
+	}
+	myappresourcelog.Info("Validation for MyAppResource upon update", "name", mar.GetName())
+
+	// A spec admitted before may not validate anymore once the cluster or
+	// the configuration changed. Updates leaving it alone, like removing
+	// the finalizer of a resource being deleted, must still go through.
+	if mar.DeletionTimestamp != nil || equality.Semantic.DeepEqual(old.Spec, mar.Spec) {
+		return nil, nil
+	}
+	return nil, v.validate(ctx, old, mar)
+}
+
+// ValidateDelete implements webhook.CustomValidator so a webhook will be registered for the type MyAppResource.
+func (v *MyAppResourceCustomValidator) ValidateDelete(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+func (v *MyAppResourceCustomValidator) validate(ctx context.Context, old, mar *myv1alpha1.MyAppResource) error {
+	opts := v.RenderOptions
+	if v.Config != nil {
+		opts = v.Config.RenderOptions(opts)
+	}
+	errs := render.Validate(*mar, opts)
+	authErrs, err := v.authorize(ctx, old, mar)
+	if err != nil {
+		return err
+	}
+	errs = append(errs, authErrs...)
+	if len(errs) == 0 {
+		return nil
+	}
+	for _, err := range errs {
+		validationFailures.WithLabelValues(string(err.Type)).Inc()
+	}
+	return apierrors.NewInvalid(myv1alpha1.GroupVersion.WithKind("MyAppResource").GroupKind(), mar.Name, errs)
+}
+
+// authorize checks that the requesting user may grant the pods of mar what
+// it asks for, since the controller creates the objects with its own
+// permissions: binding each role added to its ServiceAccount requires the
+// bind permission on the role, and running as an existing ServiceAccount
+// requires the permission to create pods, which could run as it too.
+// References already present in old were checked when they were added.
+func (v *MyAppResourceCustomValidator) authorize(ctx context.Context, old, mar *myv1alpha1.MyAppResource) (field.ErrorList, error) {
+	if v.Client == nil {
+		return nil, nil
+	}
+	req, err := admission.RequestFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var errs field.ErrorList
+	sa := mar.Spec.ServiceAccount
+	path := field.NewPath("spec", "serviceAccount")
+	if sa.Create {
+		for i, ref := range sa.Roles {
+			if old != nil && old.Spec.ServiceAccount.Create && hasRole(old.Spec.ServiceAccount.Roles, ref) {
+				continue
+			}
+			kind, resource := "Role", "roles"
+			if ref.Kind == "ClusterRole" {
+				kind, resource = "ClusterRole", "clusterroles"
+			}
+			allowed, err := v.allowed(ctx, req, &authorizationv1.ResourceAttributes{
+				Namespace: mar.Namespace,
+				Verb:      "bind",
+				Group:     rbacv1.GroupName,
+				Resource:  resource,
+				Name:      ref.Name,
+			})
+			if err != nil {
+				return nil, err
+			}
+			if !allowed {
+				errs = append(errs, field.Forbidden(path.Child("roles").Index(i),
+					fmt.Sprintf("%s may not bind %s %s", req.UserInfo.Username, kind, ref.Name)))
+			}
+		}
+	} else if sa.Name != "" && (old == nil || old.Spec.ServiceAccount.Create || old.Spec.ServiceAccount.Name != sa.Name) {
+		allowed, err := v.allowed(ctx, req, &authorizationv1.ResourceAttributes{
+			Namespace: mar.Namespace,
+			Verb:      "create",
+			Resource:  "pods",
+		})
+		if err != nil {
+			return nil, err
+		}
+		if !allowed {
+			errs = append(errs, field.Forbidden(path.Child("name"),
+				fmt.Sprintf("%s may not create pods running as ServiceAccount %s", req.UserInfo.Username, sa.Name)))
+		}
+	}
+	return errs, nil
+}
+
+// allowed asks the API server whether the user of req may perform attrs.
+func (v *MyAppResourceCustomValidator) allowed(ctx context.Context, req admission.Request,
+	attrs *authorizationv1.ResourceAttributes) (bool, error) {
+	extra := map[string]authorizationv1.ExtraValue{}
+	for k, values := range req.UserInfo.Extra {
+		extra[k] = authorizationv1.ExtraValue(values)
+	}
+	review := &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			ResourceAttributes: attrs,
+			User:               req.UserInfo.Username,
+			Groups:             req.UserInfo.Groups,
+			UID:                req.UserInfo.UID,
+			Extra:              extra,
+		},
+	}
+	if err := v.Client.Create(ctx, review); err != nil {
+		return false, fmt.Errorf("checking the permissions of %s: %w", req.UserInfo.Username, err)
+	}
+	return review.Status.Allowed, nil
+}
+
+func hasRole(refs []myv1alpha1.RoleReference, ref myv1alpha1.RoleReference) bool {
+	for _, r := range refs {
+		if r.Name == ref.Name && (r.Kind == "ClusterRole") == (ref.Kind == "ClusterRole") {
+			return true
+		}
+	}
+	return false
+}
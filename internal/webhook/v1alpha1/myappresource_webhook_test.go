@@ -0,0 +1,283 @@
+/*
+Copyright 2024 shiliohstuart6.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	myv1alpha1 "github.com/shilohstuart6/Custom-Controller.git/api/v1alpha1"
+)
+
+var _ = Describe("MyAppResource Webhook", func() {
+	var (
+		obj       *myv1alpha1.MyAppResource
+		validator MyAppResourceCustomValidator
+		ctx       = context.Background()
+	)
+
+	BeforeEach(func() {
+		obj = &myv1alpha1.MyAppResource{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "test-resource",
+				Namespace: "default",
+			},
+			Spec: myv1alpha1.MyAppResourceSpec{
+				ReplicaCount: 1,
+				Image: myv1alpha1.Image{
+					Repository: "ghcr.io/stefanprodan/podinfo",
+					Tag:        "latest",
+				},
+				Redis: myv1alpha1.Redis{Enabled: true},
+			},
+		}
+		validator = MyAppResourceCustomValidator{}
+	})
+
+	Context("When creating or updating MyAppResource under Validating Webhook", func() {
+		It("Should admit the hardened defaults at the restricted level", func() {
+			_, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("Should deny invalid resource quantities", func() {
+			obj.Spec.Resources.MemoryLimit = "lots"
+			_, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).To(MatchError(ContainSubstring("spec.resources.memoryLimit")))
+		})
+
+		It("Should count the rejected fields by reason", func() {
+			failures := testutil.ToFloat64(validationFailures.WithLabelValues("FieldValueInvalid"))
+			obj.Spec.Resources.MemoryLimit = "lots"
+			obj.Spec.Resources.CpuLimit = "fast"
+			_, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).To(HaveOccurred())
+			Expect(testutil.ToFloat64(validationFailures.WithLabelValues("FieldValueInvalid"))).To(Equal(failures + 2))
+
+			families, err := metrics.Registry.Gather()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(families).To(ContainElement(HaveField("GetName()", "myappresource_validation_failures_total")))
+		})
+
+		It("Should deny overrides that break the restricted level", func() {
+			oldObj := obj.DeepCopy()
+			obj.Spec.SecurityContext.Container = &corev1.SecurityContext{
+				AllowPrivilegeEscalation: ptr.To(true),
+			}
+			_, err := validator.ValidateUpdate(ctx, oldObj, obj)
+			Expect(err).To(MatchError(ContainSubstring("allowPrivilegeEscalation")))
+		})
+
+		It("Should admit updates leaving an invalid spec unchanged or deleting it", func() {
+			obj.Spec.Resources.MemoryLimit = "lots"
+			_, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).To(HaveOccurred())
+
+			By("Updating the metadata only")
+			oldObj := obj.DeepCopy()
+			obj.Finalizers = []string{"my.api.group/teardown"}
+			_, err = validator.ValidateUpdate(ctx, oldObj, obj)
+			Expect(err).NotTo(HaveOccurred())
+
+			By("Removing the finalizer of a resource being deleted")
+			oldObj = obj.DeepCopy()
+			obj.DeletionTimestamp = ptr.To(metav1.Now())
+			obj.Finalizers = nil
+			obj.Spec.ReplicaCount = 2
+			_, err = validator.ValidateUpdate(ctx, oldObj, obj)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("Should admit the same overrides at the baseline level", func() {
+			obj.Spec.SecurityContext.Container = &corev1.SecurityContext{
+				AllowPrivilegeEscalation: ptr.To(true),
+			}
+			obj.Spec.SecurityContext.PodSecurityLevel = "baseline"
+			_, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("Should deny privileged containers at the baseline level", func() {
+			obj.Spec.SecurityContext.Container = &corev1.SecurityContext{
+				Privileged: ptr.To(true),
+			}
+			obj.Spec.SecurityContext.PodSecurityLevel = "baseline"
+			_, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).To(MatchError(ContainSubstring("privileged")))
+		})
+
+		It("Should deny overriding reserved environment variables", func() {
+			obj.Spec.Env = []corev1.EnvVar{{Name: "PODINFO_CACHE_SERVER", Value: "tcp://elsewhere:6379"}}
+			_, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).To(MatchError(ContainSubstring("spec.env[0].name")))
+		})
+
+		It("Should deny duplicate environment variables", func() {
+			obj.Spec.Env = []corev1.EnvVar{
+				{Name: "PODINFO_LEVEL", Value: "debug"},
+				{Name: "PODINFO_LEVEL", Value: "info"},
+			}
+			_, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).To(MatchError(ContainSubstring("spec.env[1].name: Duplicate value")))
+		})
+
+		It("Should admit user volumes mounted into podinfo", func() {
+			obj.Spec.Volumes = []myv1alpha1.Volume{
+				{Name: "settings", ConfigMap: &corev1.ConfigMapVolumeSource{
+					LocalObjectReference: corev1.LocalObjectReference{Name: "podinfo-settings"},
+				}},
+				{Name: "scratch", EmptyDir: &corev1.EmptyDirVolumeSource{SizeLimit: ptr.To(resource.MustParse("64Mi"))}},
+			}
+			obj.Spec.VolumeMounts = []corev1.VolumeMount{
+				{Name: "settings", MountPath: "/etc/podinfo"},
+				{Name: "scratch", MountPath: "/scratch"},
+			}
+			obj.Spec.Persistence = myv1alpha1.Persistence{Enabled: true, Size: "2Gi"}
+			_, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("Should deny volumes with no source or colliding names", func() {
+			obj.Spec.Volumes = []myv1alpha1.Volume{{Name: "data", EmptyDir: &corev1.EmptyDirVolumeSource{}}, {Name: "empty"}}
+			_, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).To(MatchError(ContainSubstring("spec.volumes[0].name: Duplicate value")))
+			Expect(err).To(MatchError(ContainSubstring("spec.volumes[1]: Invalid value")))
+		})
+
+		It("Should deny mounts of unknown volumes", func() {
+			obj.Spec.VolumeMounts = []corev1.VolumeMount{{Name: "missing", MountPath: "/missing"}}
+			_, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).To(MatchError(ContainSubstring("spec.volumeMounts[0].name: Not found")))
+		})
+
+		It("Should deny colliding ports and malformed podinfo settings", func() {
+			obj.Spec.Podinfo = myv1alpha1.Podinfo{
+				HTTPPort:    8080,
+				GRPCPort:    8080,
+				SecurePort:  6379,
+				BackendURLs: []string{"not a url"},
+				RandomDelay: &myv1alpha1.RandomDelay{Min: 5, Max: 1},
+			}
+			_, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).To(MatchError(ContainSubstring("spec.podinfo.grpcPort: Invalid value: 8080: already used by httpPort")))
+			Expect(err).To(MatchError(ContainSubstring("spec.podinfo.securePort: Invalid value: 6379: already used by redis")))
+			Expect(err).To(MatchError(ContainSubstring("spec.podinfo.certPath: Required value")))
+			Expect(err).To(MatchError(ContainSubstring("spec.podinfo.backendURLs[0]")))
+			Expect(err).To(MatchError(ContainSubstring("spec.podinfo.randomDelay.min")))
+		})
+
+		It("Should require named ports for the generic profile", func() {
+			obj.Spec.Profile = "generic"
+			obj.Spec.Image = myv1alpha1.Image{Repository: "nginx", Tag: "1.25"}
+			obj.Spec.App.Ports = []corev1.ContainerPort{
+				{Name: "http", ContainerPort: 8080},
+				{ContainerPort: 8081},
+				{Name: "http", ContainerPort: 8080},
+			}
+			_, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).To(MatchError(ContainSubstring("spec.app.ports[1].name: Required value")))
+			Expect(err).To(MatchError(ContainSubstring("spec.app.ports[2].name: Duplicate value")))
+			Expect(err).To(MatchError(ContainSubstring("spec.app.ports[2].containerPort: Duplicate value")))
+		})
+	})
+
+	Context("When checking the permissions of the requesting user", func() {
+		var reviews []authorizationv1.ResourceAttributes
+
+		BeforeEach(func() {
+			reviews = nil
+			// The user may bind the Role "reader" and create pods in
+			// namespace "default", and nothing else.
+			validator.Client = fake.NewClientBuilder().WithInterceptorFuncs(interceptor.Funcs{
+				Create: func(_ context.Context, _ client.WithWatch, obj client.Object, _ ...client.CreateOption) error {
+					review := obj.(*authorizationv1.SubjectAccessReview)
+					Expect(review.Spec.User).To(Equal("alice"))
+					attrs := *review.Spec.ResourceAttributes
+					reviews = append(reviews, attrs)
+					review.Status.Allowed = attrs.Namespace == "default" &&
+						(attrs.Verb == "bind" && attrs.Resource == "roles" && attrs.Name == "reader" ||
+							attrs.Verb == "create" && attrs.Resource == "pods")
+					return nil
+				},
+			}).Build()
+			ctx = admission.NewContextWithRequest(context.Background(), admission.Request{
+				AdmissionRequest: admissionv1.AdmissionRequest{
+					UserInfo: authenticationv1.UserInfo{Username: "alice"},
+				},
+			})
+			DeferCleanup(func() { ctx = context.Background() })
+		})
+
+		It("Should deny binding roles the user may not bind", func() {
+			obj.Spec.ServiceAccount = myv1alpha1.ServiceAccount{
+				Create: true,
+				Roles: []myv1alpha1.RoleReference{
+					{Kind: "Role", Name: "reader"},
+					{Kind: "ClusterRole", Name: "cluster-admin"},
+				},
+			}
+			_, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).To(MatchError(ContainSubstring(
+				"spec.serviceAccount.roles[1]: Forbidden: alice may not bind ClusterRole cluster-admin")))
+			Expect(err).NotTo(MatchError(ContainSubstring("spec.serviceAccount.roles[0]")))
+			Expect(reviews).To(ContainElement(authorizationv1.ResourceAttributes{
+				Namespace: "default", Verb: "bind", Group: "rbac.authorization.k8s.io",
+				Resource: "clusterroles", Name: "cluster-admin",
+			}))
+		})
+
+		It("Should only check the roles added by an update", func() {
+			obj.Spec.ServiceAccount = myv1alpha1.ServiceAccount{
+				Create: true,
+				Roles:  []myv1alpha1.RoleReference{{Kind: "ClusterRole", Name: "edit"}},
+			}
+			oldObj := obj.DeepCopy()
+			obj.Spec.ServiceAccount.Roles = append(obj.Spec.ServiceAccount.Roles,
+				myv1alpha1.RoleReference{Kind: "Role", Name: "reader"})
+			_, err := validator.ValidateUpdate(ctx, oldObj, obj)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(reviews).To(HaveLen(1))
+			Expect(reviews[0].Name).To(Equal("reader"))
+		})
+
+		It("Should require the permission to create pods to use an existing ServiceAccount", func() {
+			obj.Spec.ServiceAccount = myv1alpha1.ServiceAccount{Name: "deployer"}
+			_, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).NotTo(HaveOccurred())
+
+			obj.Namespace = "other"
+			_, err = validator.ValidateCreate(ctx, obj)
+			Expect(err).To(MatchError(ContainSubstring(
+				"spec.serviceAccount.name: Forbidden: alice may not create pods running as ServiceAccount deployer")))
+		})
+	})
+})
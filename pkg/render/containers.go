@@ -0,0 +1,74 @@
+/*
+Copyright 2024 shiliohstuart6.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package render
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/utils/ptr"
+
+	myv1alpha1 "github.com/shilohstuart6/Custom-Controller.git/api/v1alpha1"
+)
+
+// reservedContainerNames are the containers rendered by the built-in
+// profiles, which user containers may not take the name of.
+var reservedContainerNames = map[string]bool{
+	podinfoContainerName: true,
+	redisContainerName:   true,
+	genericContainerName: true,
+}
+
+// applyExtraContainers adds spec.sidecars and spec.initContainers to the
+// pod. Sidecars become native sidecars, started before the other init
+// containers, when the cluster supports them.
+func applyExtraContainers(spec *corev1.PodSpec, mar myv1alpha1.MyAppResource, opts Options) error {
+	names := map[string]bool{}
+	for _, c := range spec.Containers {
+		names[c.Name] = true
+	}
+	claim := func(name string) error {
+		if names[name] {
+			return fmt.Errorf("container name %s is already in use", name)
+		}
+		names[name] = true
+		return nil
+	}
+
+	for _, c := range mar.Spec.Sidecars {
+		if err := claim(c.Name); err != nil {
+			return err
+		}
+		c := *c.DeepCopy()
+		if opts.NativeSidecars {
+			c.RestartPolicy = ptr.To(corev1.ContainerRestartPolicyAlways)
+			spec.InitContainers = append(spec.InitContainers, c)
+		} else {
+			spec.Containers = append(spec.Containers, c)
+		}
+	}
+	for _, c := range mar.Spec.InitContainers {
+		if err := claim(c.Name); err != nil {
+			return err
+		}
+		if c.RestartPolicy != nil && !opts.NativeSidecars {
+			return fmt.Errorf("init container %s: native sidecars are not supported by the cluster", c.Name)
+		}
+		spec.InitContainers = append(spec.InitContainers, *c.DeepCopy())
+	}
+	return nil
+}
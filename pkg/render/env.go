@@ -0,0 +1,61 @@
+/*
+Copyright 2024 shiliohstuart6.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package render
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+
+	myv1alpha1 "github.com/shilohstuart6/Custom-Controller.git/api/v1alpha1"
+)
+
+// reservedEnv are the podinfo variables the controller manages on its own
+// and does not let users override.
+var reservedEnv = map[string]bool{
+	"POD_IP":               true,
+	"PODINFO_CACHE_SERVER": true,
+}
+
+// applyEnv merges the user-supplied environment into the main container.
+// A variable already set by the controller is replaced in place, others are
+// appended in the order given.
+func applyEnv(spec *corev1.PodSpec, mar myv1alpha1.MyAppResource, main string) error {
+	c := findContainer(spec, main)
+	if c == nil {
+		return nil
+	}
+
+	for _, env := range mar.Spec.Env {
+		if reservedEnv[env.Name] {
+			return fmt.Errorf("environment variable %s is managed by the controller", env.Name)
+		}
+		replaced := false
+		for i := range c.Env {
+			if c.Env[i].Name == env.Name {
+				c.Env[i] = env
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			c.Env = append(c.Env, env)
+		}
+	}
+	c.EnvFrom = append(c.EnvFrom, mar.Spec.EnvFrom...)
+	return nil
+}
@@ -0,0 +1,84 @@
+/*
+Copyright 2024 shiliohstuart6.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package render
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	myv1alpha1 "github.com/shilohstuart6/Custom-Controller.git/api/v1alpha1"
+)
+
+const (
+	// ProfileGeneric runs spec.image with the command, args and ports given
+	// in spec.app.
+	ProfileGeneric = "generic"
+
+	// genericContainerName is the name of the main container with the
+	// generic profile.
+	genericContainerName = "app"
+)
+
+func init() {
+	Register(genericProfile{})
+}
+
+type genericProfile struct{}
+
+func (genericProfile) Name() string {
+	return ProfileGeneric
+}
+
+// Render runs spec.image as the main container, next to the redis cache
+// when spec.redis.enabled is set.
+func (genericProfile) Render(mar myv1alpha1.MyAppResource, opts Options) ([]client.Object, error) {
+	resources, err := Resources(mar)
+	if err != nil {
+		return nil, err
+	}
+
+	app := corev1.Container{
+		Name:      genericContainerName,
+		Image:     Image(mar),
+		Command:   mar.Spec.App.Command,
+		Args:      mar.Spec.App.Args,
+		Resources: resources,
+		Ports:     mar.Spec.App.Ports,
+		VolumeMounts: []corev1.VolumeMount{
+			{
+				Name:      "tmp",
+				MountPath: "/tmp",
+			},
+		},
+	}
+
+	containers := []corev1.Container{app}
+	if mar.Spec.Redis.Enabled {
+		containers = []corev1.Container{redisContainer(resources), app}
+	}
+
+	return Build(mar, Workload{Containers: containers, Main: genericContainerName}, opts)
+}
+
+func (genericProfile) Validate(mar myv1alpha1.MyAppResource, specPath *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	if mar.Spec.Image.Repository == "" {
+		errs = append(errs, field.Required(specPath.Child("image", "repository"), "required by the generic profile"))
+	}
+	return errs
+}
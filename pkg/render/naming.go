@@ -0,0 +1,123 @@
+/*
+Copyright 2024 shiliohstuart6.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package render
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/validation/path"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	myv1alpha1 "github.com/shilohstuart6/Custom-Controller.git/api/v1alpha1"
+)
+
+// maxNameLength is the longest name given to a rendered object, the limit of
+// Service names and label values.
+const maxNameLength = 63
+
+// nameHashLength is the number of hex digits of the hash ending shortened
+// names.
+const nameHashLength = 8
+
+// Fullname returns the name of the main objects rendered for the custom
+// resource, the Deployment among them. Other objects are named after it.
+func Fullname(mar myv1alpha1.MyAppResource) string {
+	return Name(mar)
+}
+
+// Name returns Fullname followed by the given parts, joined by dashes and
+// shortened to fit in 63 characters.
+func Name(mar myv1alpha1.MyAppResource, parts ...string) string {
+	name := mar.Name
+	switch {
+	case mar.Spec.FullnameOverride != "":
+		name = mar.Spec.FullnameOverride
+	case mar.Spec.NameOverride != "":
+		name += "-" + mar.Spec.NameOverride
+	}
+	name = mar.Spec.Naming.Prefix + name + mar.Spec.Naming.Suffix
+	return shorten(strings.Join(append([]string{name}, parts...), "-"))
+}
+
+// Instance returns the value of the instance label of the objects rendered
+// for the custom resource.
+func Instance(mar myv1alpha1.MyAppResource) string {
+	return shorten(mar.Name)
+}
+
+// shorten returns s if it fits in maxNameLength, and otherwise its start
+// followed by a hash of s, so distinct long names stay distinct.
+func shorten(s string) string {
+	if len(s) <= maxNameLength {
+		return s
+	}
+	sum := sha256.Sum256([]byte(s))
+	head := strings.TrimRight(s[:maxNameLength-nameHashLength-1], "-.")
+	return head + "-" + hex.EncodeToString(sum[:])[:nameHashLength]
+}
+
+// namingPath returns the field deciding the names of the objects rendered
+// for the custom resource.
+func namingPath(mar myv1alpha1.MyAppResource, specPath *field.Path) *field.Path {
+	switch {
+	case mar.Spec.FullnameOverride != "":
+		return specPath.Child("fullnameOverride")
+	case mar.Spec.NameOverride != "":
+		return specPath.Child("nameOverride")
+	case mar.Spec.Naming != myv1alpha1.Naming{}:
+		return specPath.Child("naming")
+	default:
+		return field.NewPath("metadata", "name")
+	}
+}
+
+// validateNaming checks that Fullname can name a Service. This also holds
+// for resources named after themselves, whose names may contain dots that
+// Service names may not.
+func validateNaming(mar myv1alpha1.MyAppResource, specPath *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	name := Fullname(mar)
+	for _, msg := range validation.IsDNS1035Label(name) {
+		errs = append(errs, field.Invalid(namingPath(mar, specPath), name, msg))
+	}
+	return errs
+}
+
+// validateObjectNames checks that the names derived from Fullname for the
+// rendered objects can name a Service too. RoleBindings, named after the
+// roles they bind, only need valid RBAC names.
+func validateObjectNames(mar myv1alpha1.MyAppResource, objs []client.Object, specPath *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	for _, obj := range objs {
+		name := obj.GetName()
+		var msgs []string
+		if _, ok := obj.(*rbacv1.RoleBinding); ok {
+			msgs = path.IsValidPathSegmentName(name)
+		} else {
+			msgs = validation.IsDNS1035Label(name)
+		}
+		for _, msg := range msgs {
+			errs = append(errs, field.Invalid(namingPath(mar, specPath), name, msg))
+		}
+	}
+	return errs
+}
@@ -0,0 +1,107 @@
+/*
+Copyright 2024 shiliohstuart6.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package render
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	myv1alpha1 "github.com/shilohstuart6/Custom-Controller.git/api/v1alpha1"
+)
+
+// networkPolicies returns the NetworkPolicies isolating the pods of the
+// custom resource, admitting the listed sources to the ports of the main
+// container.
+func networkPolicies(mar myv1alpha1.MyAppResource, ports []corev1.ContainerPort, redis bool) []client.Object {
+	spec := mar.Spec.NetworkPolicy
+	if !spec.Enabled {
+		return nil
+	}
+	// The instance label alone is shared with other tools, like Helm, that
+	// may run pods of the same name in the namespace.
+	instancePods := metav1.LabelSelector{MatchLabels: Labels(mar)}
+
+	app := &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      Fullname(mar),
+			Namespace: mar.Namespace,
+			Labels:    Labels(mar),
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: instancePods,
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+		},
+	}
+	// A rule without peers would admit everyone, so leave ingress closed
+	// when no sources are listed.
+	if len(spec.Ingress) > 0 {
+		rule := networkingv1.NetworkPolicyIngressRule{From: spec.Ingress}
+		for _, p := range ports {
+			rule.Ports = append(rule.Ports, namedPort(p.Name))
+		}
+		app.Spec.Ingress = []networkingv1.NetworkPolicyIngressRule{rule}
+	}
+	if len(spec.Egress) > 0 {
+		app.Spec.PolicyTypes = append(app.Spec.PolicyTypes, networkingv1.PolicyTypeEgress)
+		app.Spec.Egress = append([]networkingv1.NetworkPolicyEgressRule{dnsEgressRule()}, spec.Egress...)
+	}
+	policies := []client.Object{app}
+
+	// Redis always runs in the application pod, so the policy limiting its
+	// port to this instance's pods selects those pods; no profile runs
+	// redis in pods of its own.
+	if redis {
+		policies = append(policies, &networkingv1.NetworkPolicy{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      Name(mar, "redis"),
+				Namespace: mar.Namespace,
+				Labels:    Labels(mar),
+			},
+			Spec: networkingv1.NetworkPolicySpec{
+				PodSelector: instancePods,
+				PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+				Ingress: []networkingv1.NetworkPolicyIngressRule{
+					{
+						From:  []networkingv1.NetworkPolicyPeer{{PodSelector: &instancePods}},
+						Ports: []networkingv1.NetworkPolicyPort{namedPort("client")},
+					},
+				},
+			},
+		})
+	}
+
+	return policies
+}
+
+func namedPort(name string) networkingv1.NetworkPolicyPort {
+	port := intstr.FromString(name)
+	return networkingv1.NetworkPolicyPort{Port: &port}
+}
+
+func dnsEgressRule() networkingv1.NetworkPolicyEgressRule {
+	port := intstr.FromInt32(53)
+	udp, tcp := corev1.ProtocolUDP, corev1.ProtocolTCP
+	return networkingv1.NetworkPolicyEgressRule{
+		Ports: []networkingv1.NetworkPolicyPort{
+			{Protocol: &udp, Port: &port},
+			{Protocol: &tcp, Port: &port},
+		},
+	}
+}
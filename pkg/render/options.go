@@ -0,0 +1,70 @@
+/*
+Copyright 2024 shiliohstuart6.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package render
+
+import (
+	"k8s.io/apimachinery/pkg/util/version"
+	apimachineryversion "k8s.io/apimachinery/pkg/version"
+
+	myv1alpha1 "github.com/shilohstuart6/Custom-Controller.git/api/v1alpha1"
+)
+
+// nativeSidecarsVersion is the first release enabling the SidecarContainers
+// feature gate by default.
+var nativeSidecarsVersion = version.MajorMinor(1, 29)
+
+// Options describe the cluster the objects are rendered for.
+type Options struct {
+	// NativeSidecars is set when the cluster runs init containers with
+	// restartPolicy Always as sidecars.
+	NativeSidecars bool
+
+	// DefaultResources fill the spec.resources fields a resource leaves
+	// empty, before the built-in defaults.
+	DefaultResources myv1alpha1.RequestsAndLimits
+}
+
+// OptionsForVersion returns the options matching the features the API
+// server enables by default.
+func OptionsForVersion(info *apimachineryversion.Info) (Options, error) {
+	v, err := version.ParseGeneric(info.GitVersion)
+	if err != nil {
+		return Options{}, err
+	}
+	return Options{
+		NativeSidecars: v.AtLeast(nativeSidecarsVersion),
+	}, nil
+}
+
+// withDefaults returns the resource with the defaults of opts filled in.
+func withDefaults(mar myv1alpha1.MyAppResource, opts Options) myv1alpha1.MyAppResource {
+	r, d := &mar.Spec.Resources, opts.DefaultResources
+	for _, f := range []struct {
+		value    *string
+		fallback string
+	}{
+		{&r.MemoryRequest, d.MemoryRequest},
+		{&r.MemoryLimit, d.MemoryLimit},
+		{&r.CpuRequest, d.CpuRequest},
+		{&r.CpuLimit, d.CpuLimit},
+	} {
+		if *f.value == "" {
+			*f.value = f.fallback
+		}
+	}
+	return mar
+}
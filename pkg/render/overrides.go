@@ -0,0 +1,152 @@
+/*
+Copyright 2024 shiliohstuart6.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package render
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	kjson "sigs.k8s.io/json"
+	"sigs.k8s.io/yaml"
+
+	myv1alpha1 "github.com/shilohstuart6/Custom-Controller.git/api/v1alpha1"
+)
+
+// overrideError is returned by ApplyOverrides for an entry of
+// spec.overrides that cannot be applied.
+type overrideError struct {
+	index int
+	err   error
+}
+
+func (e *overrideError) Error() string {
+	return fmt.Sprintf("override %d: %v", e.index, e.err)
+}
+
+func (e *overrideError) Unwrap() error {
+	return e.err
+}
+
+// ApplyOverrides patches the rendered objects in place with the entries of
+// spec.overrides, in order, and returns the patches it applied. Every entry
+// must match at least one object, and may not rename it.
+func ApplyOverrides(mar myv1alpha1.MyAppResource, objs []client.Object) ([]myv1alpha1.AppliedOverride, error) {
+	var applied []myv1alpha1.AppliedOverride
+	for i, o := range mar.Spec.Overrides {
+		typ := o.Type
+		if typ == "" {
+			typ = myv1alpha1.StrategicMergeOverride
+		}
+		patch, err := yaml.YAMLToJSON([]byte(o.Patch))
+		if err != nil {
+			return nil, &overrideError{i, fmt.Errorf("parsing patch: %w", err)}
+		}
+		digest := sha256.Sum256([]byte(o.Patch))
+
+		matched := false
+		for j, obj := range objs {
+			kind := kindOf(obj)
+			if kind != o.Target.Kind || (o.Target.Name != "" && obj.GetName() != o.Target.Name) {
+				continue
+			}
+			patched, err := patchObject(obj, typ, patch)
+			if err != nil {
+				return nil, &overrideError{i, fmt.Errorf("patching %s %s: %w", kind, obj.GetName(), err)}
+			}
+			objs[j] = patched
+			matched = true
+			applied = append(applied, myv1alpha1.AppliedOverride{
+				Index:  int32(i),
+				Kind:   kind,
+				Name:   obj.GetName(),
+				Type:   typ,
+				Digest: hex.EncodeToString(digest[:]),
+			})
+		}
+		if !matched {
+			target := o.Target.Kind
+			if o.Target.Name != "" {
+				target += " " + o.Target.Name
+			}
+			return nil, &overrideError{i, fmt.Errorf("no rendered %s to patch", target)}
+		}
+	}
+	return applied, nil
+}
+
+func kindOf(obj client.Object) string {
+	if gvk, err := apiutil.GVKForObject(obj, scheme.Scheme); err == nil {
+		return gvk.Kind
+	}
+	return obj.GetObjectKind().GroupVersionKind().Kind
+}
+
+// patchObject returns a copy of obj with the patch applied. Strategic merge
+// patches need the Go type of the object, so unstructured objects get a
+// JSON merge patch instead.
+func patchObject(obj client.Object, typ myv1alpha1.OverrideType, patch []byte) (client.Object, error) {
+	original, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []byte
+	switch _, unstructured := obj.(runtime.Unstructured); {
+	case typ == myv1alpha1.JSONOverride:
+		ops, err := jsonpatch.DecodePatch(patch)
+		if err != nil {
+			return nil, err
+		}
+		out, err = ops.Apply(original)
+		if err != nil {
+			return nil, err
+		}
+	case unstructured:
+		out, err = jsonpatch.MergePatch(original, patch)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		out, err = strategicpatch.StrategicMergePatch(original, patch, obj)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	patched := reflect.New(reflect.TypeOf(obj).Elem()).Interface().(client.Object)
+	strict, err := kjson.UnmarshalStrict(out, patched)
+	if err != nil {
+		return nil, err
+	}
+	if len(strict) > 0 {
+		return nil, errors.Join(strict...)
+	}
+	if patched.GetName() != obj.GetName() || patched.GetNamespace() != obj.GetNamespace() {
+		return nil, errors.New("the name and namespace cannot be changed")
+	}
+	return patched, nil
+}
@@ -0,0 +1,200 @@
+/*
+Copyright 2024 shiliohstuart6.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package render
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	myv1alpha1 "github.com/shilohstuart6/Custom-Controller.git/api/v1alpha1"
+)
+
+const (
+	// ProfilePodinfo runs podinfo configured through spec.ui and
+	// spec.podinfo.
+	ProfilePodinfo = "podinfo"
+	// ProfilePodinfoRedis runs podinfo with a redis cache in the same pod.
+	ProfilePodinfoRedis = "podinfo-redis"
+
+	podinfoContainerName       = "podinfo"
+	defaultHTTPPort      int32 = 9898
+)
+
+func init() {
+	Register(podinfoProfile{})
+	Register(podinfoProfile{redis: true})
+}
+
+type podinfoProfile struct {
+	redis bool
+}
+
+func (p podinfoProfile) Name() string {
+	if p.redis {
+		return ProfilePodinfoRedis
+	}
+	return ProfilePodinfo
+}
+
+// Render runs podinfo as the main container. The command and ports come
+// from spec.podinfo unless spec.app sets them.
+func (p podinfoProfile) Render(mar myv1alpha1.MyAppResource, opts Options) ([]client.Object, error) {
+	resources, err := Resources(mar)
+	if err != nil {
+		return nil, err
+	}
+
+	podinfo := corev1.Container{
+		Name:      podinfoContainerName,
+		Image:     Image(mar),
+		Command:   mar.Spec.App.Command,
+		Args:      mar.Spec.App.Args,
+		Resources: resources,
+		Ports:     mar.Spec.App.Ports,
+		Env:       uiEnv(mar),
+		VolumeMounts: []corev1.VolumeMount{
+			{
+				Name:      "tmp",
+				MountPath: "/tmp",
+			},
+		},
+	}
+	if len(podinfo.Command) == 0 {
+		podinfo.Command = podinfoCommand(mar)
+	}
+	if len(podinfo.Ports) == 0 {
+		podinfo.Ports = podinfoPorts(mar)
+	}
+
+	containers := []corev1.Container{podinfo}
+	if p.redis {
+		podinfo.Env = append([]corev1.EnvVar{
+			{
+				Name: "POD_IP",
+				ValueFrom: &corev1.EnvVarSource{
+					FieldRef: &corev1.ObjectFieldSelector{
+						FieldPath: "status.podIP",
+					},
+				},
+			},
+		}, podinfo.Env...)
+		podinfo.Env = append(podinfo.Env, corev1.EnvVar{
+			Name:  "PODINFO_CACHE_SERVER",
+			Value: fmt.Sprintf("tcp://$(POD_IP):%d", redisPort),
+		})
+		containers = []corev1.Container{redisContainer(resources), podinfo}
+	}
+
+	return Build(mar, Workload{Containers: containers, Main: podinfoContainerName}, opts)
+}
+
+func (p podinfoProfile) Validate(mar myv1alpha1.MyAppResource, specPath *field.Path) field.ErrorList {
+	return validatePodinfo(mar, specPath)
+}
+
+func httpPort(mar myv1alpha1.MyAppResource) int32 {
+	if mar.Spec.Podinfo.HTTPPort == 0 {
+		return defaultHTTPPort
+	}
+	return mar.Spec.Podinfo.HTTPPort
+}
+
+// podinfoCommand returns the podinfo command line for the settings in
+// spec.podinfo.
+func podinfoCommand(mar myv1alpha1.MyAppResource) []string {
+	p := mar.Spec.Podinfo
+	cmd := []string{"./podinfo", fmt.Sprintf("--port=%d", httpPort(mar))}
+
+	if p.LogLevel != "" {
+		cmd = append(cmd, "--level="+p.LogLevel)
+	}
+	if p.GRPCPort != 0 {
+		cmd = append(cmd, fmt.Sprintf("--grpc-port=%d", p.GRPCPort))
+	}
+	if p.SecurePort != 0 {
+		cmd = append(cmd, fmt.Sprintf("--secure-port=%d", p.SecurePort))
+	}
+	if p.CertPath != "" {
+		cmd = append(cmd, "--cert-path="+p.CertPath)
+	}
+	for _, url := range p.BackendURLs {
+		cmd = append(cmd, "--backend-url="+url)
+	}
+	if d := p.RandomDelay; d != nil {
+		cmd = append(cmd, "--random-delay",
+			fmt.Sprintf("--random-delay-min=%d", d.Min),
+			fmt.Sprintf("--random-delay-max=%d", d.Max))
+		if d.Unit != "" {
+			cmd = append(cmd, "--random-delay-unit="+d.Unit)
+		}
+	}
+	if p.RandomError {
+		cmd = append(cmd, "--random-error")
+	}
+	return cmd
+}
+
+// podinfoPorts returns the container ports podinfo listens on.
+func podinfoPorts(mar myv1alpha1.MyAppResource) []corev1.ContainerPort {
+	p := mar.Spec.Podinfo
+	ports := []corev1.ContainerPort{
+		{
+			Name:          "http",
+			ContainerPort: httpPort(mar),
+			Protocol:      "TCP",
+		},
+	}
+	if p.GRPCPort != 0 {
+		ports = append(ports, corev1.ContainerPort{
+			Name:          "grpc",
+			ContainerPort: p.GRPCPort,
+			Protocol:      "TCP",
+		})
+	}
+	if p.SecurePort != 0 {
+		ports = append(ports, corev1.ContainerPort{
+			Name:          "https",
+			ContainerPort: p.SecurePort,
+			Protocol:      "TCP",
+		})
+	}
+	return ports
+}
+
+// uiEnv returns the podinfo variables derived from spec.ui.
+func uiEnv(mar myv1alpha1.MyAppResource) []corev1.EnvVar {
+	env := []corev1.EnvVar{
+		{
+			Name:  "PODINFO_UI_COLOR",
+			Value: mar.Spec.UI.Color,
+		},
+		{
+			Name:  "PODINFO_UI_MESSAGE",
+			Value: mar.Spec.UI.Message,
+		},
+	}
+	if mar.Spec.UI.Logo != "" {
+		env = append(env, corev1.EnvVar{
+			Name:  "PODINFO_UI_LOGO",
+			Value: mar.Spec.UI.Logo,
+		})
+	}
+	return env
+}
@@ -0,0 +1,117 @@
+/*
+Copyright 2024 shiliohstuart6.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package render
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+
+	myv1alpha1 "github.com/shilohstuart6/Custom-Controller.git/api/v1alpha1"
+)
+
+const (
+	redisContainerName       = "redis"
+	redisPort          int32 = 6379
+
+	redisSnapshotVolume = "redis-snapshot"
+	redisSnapshotPath   = "/snapshot"
+)
+
+// RunsRedis reports whether the pods of the resource include the redis
+// cache, as selected by its profile.
+func RunsRedis(mar myv1alpha1.MyAppResource) bool {
+	switch ProfileName(mar) {
+	case ProfilePodinfoRedis:
+		return true
+	case ProfileGeneric:
+		return mar.Spec.Redis.Enabled
+	}
+	return false
+}
+
+// redisContainer returns the redis cache container, sharing the resources
+// of the main container.
+func redisContainer(resources corev1.ResourceRequirements) corev1.Container {
+	return corev1.Container{
+		Name:      redisContainerName,
+		Image:     "redis:latest",
+		Command:   []string{"redis-server"},
+		Resources: *resources.DeepCopy(),
+		Ports: []corev1.ContainerPort{
+			{
+				Name:          "client",
+				ContainerPort: redisPort,
+				Protocol:      "TCP",
+			},
+		},
+		VolumeMounts: []corev1.VolumeMount{
+			{
+				Name:      "conf",
+				MountPath: "/conf",
+			},
+			{
+				Name:      "data",
+				MountPath: "/data",
+			},
+		},
+	}
+}
+
+// applyRedisSnapshot mounts the snapshot claim into the redis container and
+// makes redis save its data there before it stops.
+func applyRedisSnapshot(spec *corev1.PodSpec, mar myv1alpha1.MyAppResource) {
+	claim := mar.Spec.Redis.SnapshotClaimName
+	c := findContainer(spec, redisContainerName)
+	if claim == "" || c == nil {
+		return
+	}
+	spec.Volumes = append(spec.Volumes, corev1.Volume{
+		Name: redisSnapshotVolume,
+		VolumeSource: corev1.VolumeSource{
+			PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: claim},
+		},
+	})
+	c.VolumeMounts = append(c.VolumeMounts, corev1.VolumeMount{
+		Name:      redisSnapshotVolume,
+		MountPath: redisSnapshotPath,
+	})
+	c.Lifecycle = &corev1.Lifecycle{
+		PreStop: &corev1.LifecycleHandler{
+			Exec: &corev1.ExecAction{
+				Command: []string{"redis-cli", "--rdb", redisSnapshotPath + "/redis.rdb"},
+			},
+		},
+	}
+}
+
+func validateRedis(mar myv1alpha1.MyAppResource, specPath *field.Path) field.ErrorList {
+	var errs field.ErrorList
+
+	claim := mar.Spec.Redis.SnapshotClaimName
+	if claim == "" {
+		return nil
+	}
+	path := specPath.Child("redis", "snapshotClaimName")
+	if !RunsRedis(mar) {
+		errs = append(errs, field.Forbidden(path, "the profile "+ProfileName(mar)+" does not run redis"))
+	}
+	for _, msg := range validation.IsDNS1123Subdomain(claim) {
+		errs = append(errs, field.Invalid(path, claim, msg))
+	}
+	return errs
+}
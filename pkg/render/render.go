@@ -0,0 +1,148 @@
+/*
+Copyright 2024 shiliohstuart6.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package render turns a MyAppResource into the Kubernetes objects the
+// controller applies. Each value of spec.profile is served by a Profile
+// registered with Register; the built-in podinfo, podinfo-redis and generic
+// profiles register themselves when the package is loaded.
+//
+// In-house profiles are added by registering them from an init function and
+// importing that package into a custom build of cmd/main.go:
+//
+//	func init() {
+//		render.Register(myProfile{})
+//	}
+package render
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	myv1alpha1 "github.com/shilohstuart6/Custom-Controller.git/api/v1alpha1"
+)
+
+// Renderer turns a MyAppResource into the objects making up the
+// application on a cluster described by opts. The objects carry a namespace
+// and name; owner references are set by the controller. The resource must
+// not be modified.
+type Renderer interface {
+	Render(mar myv1alpha1.MyAppResource, opts Options) ([]client.Object, error)
+}
+
+// Profile is a Renderer selectable through spec.profile.
+type Profile interface {
+	Renderer
+
+	// Name is the value of spec.profile selecting the profile.
+	Name() string
+}
+
+// Validator is implemented by profiles that check the settings they read
+// from the spec. It is called by Validate before the resource is rendered.
+type Validator interface {
+	Validate(mar myv1alpha1.MyAppResource, specPath *field.Path) field.ErrorList
+}
+
+// Finalizer is implemented by profiles that create artifacts outside the
+// cluster. Finalize is called while the custom resource is being deleted,
+// once its pods have stopped, and is retried until it returns nil. It is not
+// called when the deletion policy orphans the objects.
+type Finalizer interface {
+	Finalize(ctx context.Context, mar myv1alpha1.MyAppResource) error
+}
+
+var (
+	mu       sync.RWMutex
+	profiles = map[string]Profile{}
+)
+
+// Register makes a profile available under its name. It panics if the name
+// is empty or already registered.
+func Register(p Profile) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	name := p.Name()
+	if name == "" {
+		panic("render: profile name is empty")
+	}
+	if _, dup := profiles[name]; dup {
+		panic("render: profile " + name + " registered twice")
+	}
+	profiles[name] = p
+}
+
+// Lookup returns the profile registered under name.
+func Lookup(name string) (Profile, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	p, ok := profiles[name]
+	return p, ok
+}
+
+// Profiles returns the sorted names of the registered profiles.
+func Profiles() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ProfileName returns the profile the resource selects. Resources written
+// before spec.profile existed leave it empty and pick redis through
+// spec.redis.enabled, so "" and "podinfo" resolve to "podinfo-redis" when
+// it is set.
+func ProfileName(mar myv1alpha1.MyAppResource) string {
+	switch mar.Spec.Profile {
+	case "", ProfilePodinfo:
+		if mar.Spec.Redis.Enabled {
+			return ProfilePodinfoRedis
+		}
+		return ProfilePodinfo
+	}
+	return mar.Spec.Profile
+}
+
+// ForResource returns the profile the resource selects.
+func ForResource(mar myv1alpha1.MyAppResource) (Profile, error) {
+	name := ProfileName(mar)
+	p, ok := Lookup(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown profile %q", name)
+	}
+	return p, nil
+}
+
+// Render renders the resource with the profile it selects. The overrides
+// of the resource are left to ApplyOverrides.
+func Render(mar myv1alpha1.MyAppResource, opts Options) ([]client.Object, error) {
+	p, err := ForResource(mar)
+	if err != nil {
+		return nil, err
+	}
+	return p.Render(withDefaults(mar, opts), opts)
+}
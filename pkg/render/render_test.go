@@ -0,0 +1,423 @@
+/*
+Copyright 2024 shiliohstuart6.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package render_test
+
+import (
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/version"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	myv1alpha1 "github.com/shilohstuart6/Custom-Controller.git/api/v1alpha1"
+	"github.com/shilohstuart6/Custom-Controller.git/pkg/render"
+)
+
+// echoProfile is an in-house profile built on the shared workload.
+type echoProfile struct{}
+
+func (echoProfile) Name() string { return "echo" }
+
+func (echoProfile) Render(mar myv1alpha1.MyAppResource, opts render.Options) ([]client.Object, error) {
+	resources, err := render.Resources(mar)
+	if err != nil {
+		return nil, err
+	}
+	return render.Build(mar, render.Workload{
+		Containers: []corev1.Container{{
+			Name:      "echo",
+			Image:     "hashicorp/http-echo:1.0",
+			Args:      []string{"-listen=:5678", "-text=" + mar.Spec.UI.Message},
+			Resources: resources,
+			Ports:     []corev1.ContainerPort{{Name: "http", ContainerPort: 5678, Protocol: "TCP"}},
+		}},
+		Main: "echo",
+	}, opts)
+}
+
+func init() {
+	render.Register(echoProfile{})
+}
+
+func deploymentOf(objs []client.Object) *appsv1.Deployment {
+	for _, obj := range objs {
+		if d, ok := obj.(*appsv1.Deployment); ok {
+			return d
+		}
+	}
+	return nil
+}
+
+func containerNames(d *appsv1.Deployment) []string {
+	var names []string
+	for _, c := range d.Spec.Template.Spec.Containers {
+		names = append(names, c.Name)
+	}
+	return names
+}
+
+var _ = Describe("Render", func() {
+	var mar myv1alpha1.MyAppResource
+
+	BeforeEach(func() {
+		mar = myv1alpha1.MyAppResource{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "test-resource",
+				Namespace: "default",
+			},
+			Spec: myv1alpha1.MyAppResourceSpec{
+				ReplicaCount: 1,
+				Image: myv1alpha1.Image{
+					Repository: "ghcr.io/stefanprodan/podinfo",
+					Tag:        "latest",
+				},
+			},
+		}
+	})
+
+	It("Should register the built-in profiles", func() {
+		Expect(render.Profiles()).To(ContainElements("generic", "podinfo", "podinfo-redis"))
+	})
+
+	It("Should panic when a profile is registered twice", func() {
+		Expect(func() { render.Register(echoProfile{}) }).To(Panic())
+	})
+
+	It("Should select podinfo-redis through spec.redis.enabled", func() {
+		Expect(render.ProfileName(mar)).To(Equal(render.ProfilePodinfo))
+		mar.Spec.Redis.Enabled = true
+		Expect(render.ProfileName(mar)).To(Equal(render.ProfilePodinfoRedis))
+		mar.Spec.Profile = "podinfo"
+		Expect(render.ProfileName(mar)).To(Equal(render.ProfilePodinfoRedis))
+		mar.Spec.Profile = "generic"
+		Expect(render.ProfileName(mar)).To(Equal(render.ProfileGeneric))
+	})
+
+	It("Should render podinfo with a redis cache", func() {
+		mar.Spec.Profile = render.ProfilePodinfoRedis
+		mar.Spec.NetworkPolicy.Enabled = true
+		objs, err := render.Render(mar, render.Options{})
+		Expect(err).NotTo(HaveOccurred())
+
+		d := deploymentOf(objs)
+		Expect(d).NotTo(BeNil())
+		Expect(containerNames(d)).To(Equal([]string{"redis", "podinfo"}))
+		podinfo := d.Spec.Template.Spec.Containers[1]
+		Expect(podinfo.Command).To(Equal([]string{"./podinfo", "--port=9898"}))
+		Expect(podinfo.Env[0].Name).To(Equal("POD_IP"))
+		Expect(podinfo.Env[len(podinfo.Env)-1].Value).To(Equal("tcp://$(POD_IP):6379"))
+
+		var policies []string
+		for _, obj := range objs {
+			if np, ok := obj.(*networkingv1.NetworkPolicy); ok {
+				policies = append(policies, np.Name)
+				Expect(np.Spec.PodSelector.MatchLabels).To(Equal(render.Labels(mar)))
+				if np.Name == "test-resource-redis" {
+					Expect(np.Spec.Ingress[0].From[0].PodSelector.MatchLabels).To(Equal(render.Labels(mar)))
+				}
+			}
+		}
+		Expect(policies).To(Equal([]string{"test-resource", "test-resource-redis"}))
+	})
+
+	It("Should make redis save a snapshot to the configured claim when it stops", func() {
+		mar.Spec.Redis.SnapshotClaimName = "redis-snapshots"
+		Expect(render.Validate(mar, render.Options{}).ToAggregate()).To(MatchError(ContainSubstring(
+			"spec.redis.snapshotClaimName: Forbidden: the profile podinfo does not run redis")))
+
+		mar.Spec.Redis.Enabled = true
+		Expect(render.Validate(mar, render.Options{})).To(BeEmpty())
+		objs, err := render.Render(mar, render.Options{})
+		Expect(err).NotTo(HaveOccurred())
+
+		d := deploymentOf(objs)
+		redis := d.Spec.Template.Spec.Containers[0]
+		Expect(redis.Lifecycle.PreStop.Exec.Command).To(Equal([]string{"redis-cli", "--rdb", "/snapshot/redis.rdb"}))
+		Expect(redis.VolumeMounts).To(ContainElement(corev1.VolumeMount{Name: "redis-snapshot", MountPath: "/snapshot"}))
+		Expect(d.Spec.Template.Spec.Volumes).To(ContainElement(HaveField("PersistentVolumeClaim.ClaimName", "redis-snapshots")))
+
+		By("making the claim writable for redis without persistence")
+		Expect(mar.Spec.Persistence.Enabled).To(BeFalse())
+		Expect(d.Spec.Template.Spec.SecurityContext.FSGroup).To(Equal(ptr.To[int64](999)))
+	})
+
+	It("Should fill the resources left empty from the configured defaults", func() {
+		mar.Spec.Resources.CpuLimit = "300m"
+		opts := render.Options{DefaultResources: myv1alpha1.RequestsAndLimits{CpuLimit: "1", MemoryLimit: "256Mi"}}
+		objs, err := render.Render(mar, opts)
+		Expect(err).NotTo(HaveOccurred())
+
+		limits := deploymentOf(objs).Spec.Template.Spec.Containers[0].Resources.Limits
+		Expect(limits.Cpu().String()).To(Equal("300m"))
+		Expect(limits.Memory().String()).To(Equal("256Mi"))
+		Expect(render.Validate(mar, opts)).To(BeEmpty())
+	})
+
+	It("Should not render a Service for a generic app without ports", func() {
+		mar.Spec.Profile = render.ProfileGeneric
+		objs, err := render.Render(mar, render.Options{})
+		Expect(err).NotTo(HaveOccurred())
+		for _, obj := range objs {
+			Expect(obj).NotTo(BeAssignableToTypeOf(&corev1.Service{}))
+		}
+		Expect(containerNames(deploymentOf(objs))).To(Equal([]string{"app"}))
+	})
+
+	It("Should render registered in-house profiles with the shared defaults", func() {
+		mar.Spec.Profile = "echo"
+		mar.Spec.Env = []corev1.EnvVar{{Name: "TEAM", Value: "platform"}}
+		objs, err := render.Render(mar, render.Options{})
+		Expect(err).NotTo(HaveOccurred())
+
+		d := deploymentOf(objs)
+		Expect(containerNames(d)).To(Equal([]string{"echo"}))
+		echo := d.Spec.Template.Spec.Containers[0]
+		Expect(echo.Env).To(ContainElement(corev1.EnvVar{Name: "TEAM", Value: "platform"}))
+		Expect(*echo.SecurityContext.RunAsNonRoot).To(BeTrue())
+		Expect(d.Spec.Template.Labels).To(HaveKeyWithValue(render.InstanceLabel, "test-resource"))
+
+		svc, ok := objs[len(objs)-2].(*corev1.Service)
+		Expect(ok).To(BeTrue())
+		Expect(svc.Spec.Ports[0].Port).To(BeEquivalentTo(5678))
+		Expect(render.Validate(mar, render.Options{})).To(BeEmpty())
+	})
+
+	It("Should spread only the instance's pods across zones", func() {
+		mar.Spec.Scheduling.SpreadAcrossZones = true
+		objs, err := render.Render(mar, render.Options{})
+		Expect(err).NotTo(HaveOccurred())
+
+		constraints := deploymentOf(objs).Spec.Template.Spec.TopologySpreadConstraints
+		Expect(constraints).To(HaveLen(2))
+		for _, c := range constraints {
+			Expect(c.LabelSelector.MatchLabels).To(Equal(render.Labels(mar)))
+		}
+	})
+
+	It("Should add sidecars as containers on older clusters", func() {
+		mar.Spec.Sidecars = []corev1.Container{{Name: "shipper", Image: "fluent/fluent-bit:3.0"}}
+		mar.Spec.InitContainers = []corev1.Container{{Name: "warm-up", Image: "busybox:1.36"}}
+		objs, err := render.Render(mar, render.Options{})
+		Expect(err).NotTo(HaveOccurred())
+
+		d := deploymentOf(objs)
+		Expect(containerNames(d)).To(Equal([]string{"podinfo", "shipper"}))
+		Expect(d.Spec.Template.Spec.InitContainers).To(HaveLen(1))
+		Expect(d.Spec.Template.Spec.InitContainers[0].Name).To(Equal("warm-up"))
+		Expect(*d.Spec.Template.Spec.InitContainers[0].SecurityContext.RunAsNonRoot).To(BeTrue())
+	})
+
+	It("Should run containers of unknown images as a non-root uid", func() {
+		mar.Spec.Profile = render.ProfileGeneric
+		mar.Spec.Sidecars = []corev1.Container{
+			{Name: "shipper", Image: "fluent/fluent-bit:3.0"},
+			{
+				Name:            "agent",
+				Image:           "example.com/agent:1.0",
+				SecurityContext: &corev1.SecurityContext{RunAsUser: ptr.To[int64](1000)},
+			},
+		}
+		objs, err := render.Render(mar, render.Options{})
+		Expect(err).NotTo(HaveOccurred())
+
+		uids := map[string]int64{}
+		for _, c := range deploymentOf(objs).Spec.Template.Spec.Containers {
+			Expect(c.SecurityContext.RunAsUser).NotTo(BeNil())
+			uids[c.Name] = *c.SecurityContext.RunAsUser
+		}
+		Expect(uids).To(Equal(map[string]int64{"app": 65532, "shipper": 65532, "agent": 1000}))
+
+		By("leaving the uid to the pod when it sets one")
+		mar.Spec.SecurityContext.Pod = &corev1.PodSecurityContext{RunAsUser: ptr.To[int64](2000)}
+		objs, err = render.Render(mar, render.Options{})
+		Expect(err).NotTo(HaveOccurred())
+		for _, c := range deploymentOf(objs).Spec.Template.Spec.Containers {
+			if c.Name != "agent" {
+				Expect(c.SecurityContext.RunAsUser).To(BeNil())
+			}
+		}
+	})
+
+	It("Should start sidecars natively on clusters supporting them", func() {
+		mar.Spec.Sidecars = []corev1.Container{{
+			Name:            "proxy",
+			Image:           "gcr.io/cloud-sql-connectors/cloud-sql-proxy:2.11",
+			SecurityContext: &corev1.SecurityContext{ReadOnlyRootFilesystem: ptr.To(false)},
+		}}
+		mar.Spec.InitContainers = []corev1.Container{{Name: "warm-up", Image: "busybox:1.36"}}
+		opts, err := render.OptionsForVersion(&version.Info{GitVersion: "v1.29.2"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(opts.NativeSidecars).To(BeTrue())
+
+		objs, err := render.Render(mar, opts)
+		Expect(err).NotTo(HaveOccurred())
+
+		d := deploymentOf(objs)
+		Expect(containerNames(d)).To(Equal([]string{"podinfo"}))
+		init := d.Spec.Template.Spec.InitContainers
+		Expect(init).To(HaveLen(2))
+		Expect(init[0].Name).To(Equal("proxy"))
+		Expect(*init[0].RestartPolicy).To(Equal(corev1.ContainerRestartPolicyAlways))
+		Expect(*init[0].SecurityContext.ReadOnlyRootFilesystem).To(BeFalse())
+		Expect(init[1].RestartPolicy).To(BeNil())
+		Expect(render.Validate(mar, opts)).To(BeEmpty())
+	})
+
+	It("Should reject colliding container names and unsupported native sidecars", func() {
+		mar.Spec.Sidecars = []corev1.Container{{Name: "redis", Image: "redis:7"}}
+		mar.Spec.InitContainers = []corev1.Container{
+			{Name: "shipper", Image: "fluent/fluent-bit:3.0", RestartPolicy: ptr.To(corev1.ContainerRestartPolicyAlways)},
+			{Name: "shipper", Image: "busybox:1.36"},
+		}
+		opts, err := render.OptionsForVersion(&version.Info{GitVersion: "v1.28.5"})
+		Expect(err).NotTo(HaveOccurred())
+
+		errs := render.Validate(mar, opts).ToAggregate()
+		Expect(errs).To(MatchError(ContainSubstring("spec.sidecars[0].name: Forbidden")))
+		Expect(errs).To(MatchError(ContainSubstring("spec.initContainers[0].restartPolicy: Forbidden")))
+		Expect(errs).To(MatchError(ContainSubstring("spec.initContainers[1].name: Duplicate value")))
+	})
+
+	It("Should patch rendered objects with overrides", func() {
+		mar.Spec.Overrides = []myv1alpha1.Override{
+			{
+				Target: myv1alpha1.OverrideTarget{Kind: "Deployment"},
+				Patch: `
+spec:
+  revisionHistoryLimit: 3
+  template:
+    spec:
+      containers:
+      - name: podinfo
+        imagePullPolicy: Always
+`,
+			},
+			{
+				Target: myv1alpha1.OverrideTarget{Kind: "Service", Name: "test-resource"},
+				Type:   myv1alpha1.JSONOverride,
+				Patch:  `[{"op": "add", "path": "/metadata/annotations", "value": {"team": "platform"}}]`,
+			},
+		}
+		objs, err := render.Render(mar, render.Options{})
+		Expect(err).NotTo(HaveOccurred())
+		applied, err := render.ApplyOverrides(mar, objs)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(applied).To(HaveLen(2))
+		Expect(applied[0].Kind).To(Equal("Deployment"))
+		Expect(applied[0].Type).To(Equal(myv1alpha1.StrategicMergeOverride))
+		Expect(applied[1].Name).To(Equal("test-resource"))
+		Expect(applied[1].Digest).To(HaveLen(64))
+
+		d := deploymentOf(objs)
+		Expect(*d.Spec.RevisionHistoryLimit).To(BeEquivalentTo(3))
+		podinfo := d.Spec.Template.Spec.Containers[0]
+		Expect(podinfo.ImagePullPolicy).To(Equal(corev1.PullAlways))
+		Expect(podinfo.Image).To(Equal("ghcr.io/stefanprodan/podinfo:latest"))
+		svc, ok := objs[len(objs)-2].(*corev1.Service)
+		Expect(ok).To(BeTrue())
+		Expect(svc.Annotations).To(HaveKeyWithValue("team", "platform"))
+	})
+
+	It("Should reject overrides that match nothing or do not fit the object", func() {
+		mar.Spec.Overrides = []myv1alpha1.Override{{
+			Target: myv1alpha1.OverrideTarget{Kind: "HorizontalPodAutoscaler"},
+			Patch:  `{"spec": {"minReplicas": 2}}`,
+		}}
+		Expect(render.Validate(mar, render.Options{}).ToAggregate()).To(MatchError(ContainSubstring(
+			"spec.overrides[0]: Invalid value: \"HorizontalPodAutoscaler\": no rendered HorizontalPodAutoscaler to patch")))
+
+		mar.Spec.Overrides[0].Target.Kind = "Deployment"
+		mar.Spec.Overrides[0].Patch = `{"spec": {"replica": 2}}`
+		Expect(render.Validate(mar, render.Options{}).ToAggregate()).To(MatchError(ContainSubstring(`unknown field "spec.replica"`)))
+
+		mar.Spec.Overrides[0].Patch = `{"metadata": {"name": "elsewhere"}}`
+		Expect(render.Validate(mar, render.Options{}).ToAggregate()).To(MatchError(ContainSubstring("name and namespace cannot be changed")))
+	})
+
+	It("Should reject unknown profiles", func() {
+		mar.Spec.Profile = "missing"
+		_, err := render.Render(mar, render.Options{})
+		Expect(err).To(MatchError(ContainSubstring(`unknown profile "missing"`)))
+		Expect(render.Validate(mar, render.Options{}).ToAggregate()).To(MatchError(ContainSubstring("spec.profile: Unsupported value")))
+	})
+
+	It("Should name every rendered object through the naming fields", func() {
+		mar.Spec.Redis.Enabled = true
+		mar.Spec.NetworkPolicy.Enabled = true
+		mar.Spec.Persistence.Enabled = true
+		mar.Spec.ServiceAccount.Create = true
+		mar.Spec.ServiceAccount.Roles = []myv1alpha1.RoleReference{{Kind: "ClusterRole", Name: "view"}}
+		mar.Spec.NameOverride = "canary"
+		mar.Spec.Naming = myv1alpha1.Naming{Prefix: "team-", Suffix: "-v2"}
+		objs, err := render.Render(mar, render.Options{})
+		Expect(err).NotTo(HaveOccurred())
+
+		var names []string
+		for _, obj := range objs {
+			names = append(names, obj.GetName())
+			Expect(obj.GetLabels()).To(HaveKeyWithValue(render.InstanceLabel, "test-resource"))
+		}
+		Expect(names).To(ConsistOf(
+			"team-test-resource-canary-v2",
+			"team-test-resource-canary-v2-clusterrole-view",
+			"team-test-resource-canary-v2",
+			"team-test-resource-canary-v2-redis",
+			"team-test-resource-canary-v2-data",
+			"team-test-resource-canary-v2",
+			"team-test-resource-canary-v2",
+		))
+		d := deploymentOf(objs)
+		Expect(d.Spec.Template.Spec.ServiceAccountName).To(Equal("team-test-resource-canary-v2"))
+		// Flavors of the same resource run side by side without selecting
+		// each other's pods.
+		Expect(d.Spec.Selector.MatchLabels).To(Equal(render.Labels(mar)))
+
+		mar.Spec.FullnameOverride = "flavor-b"
+		Expect(render.Fullname(mar)).To(Equal("team-flavor-b-v2"))
+		Expect(render.Validate(mar, render.Options{})).To(BeEmpty())
+	})
+
+	It("Should shorten names and label values longer than 63 characters", func() {
+		mar.Name = strings.Repeat("a", 70)
+		mar.Spec.Persistence.Enabled = true
+		Expect(render.Fullname(mar)).To(HaveLen(63))
+		Expect(render.Instance(mar)).To(Equal(render.Fullname(mar)))
+		Expect(render.Name(mar, "data")).To(HaveLen(63))
+		Expect(render.Name(mar, "data")).NotTo(Equal(render.Fullname(mar)))
+		Expect(render.Validate(mar, render.Options{})).To(BeEmpty())
+
+		mar.Name = "test-resource"
+		mar.Spec.Naming.Prefix = "1-"
+		Expect(render.Validate(mar, render.Options{}).ToAggregate()).To(MatchError(ContainSubstring("spec.naming: Invalid value")))
+	})
+
+	It("Should reject resource names that cannot name a Service", func() {
+		mar.Name = "podinfo.v2"
+		Expect(render.Validate(mar, render.Options{}).ToAggregate()).To(MatchError(ContainSubstring(
+			`metadata.name: Invalid value: "podinfo.v2"`)))
+
+		mar.Spec.FullnameOverride = "podinfo-v2"
+		Expect(render.Validate(mar, render.Options{})).To(BeEmpty())
+	})
+})
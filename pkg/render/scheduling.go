@@ -0,0 +1,82 @@
+/*
+Copyright 2024 shiliohstuart6.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package render
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	myv1alpha1 "github.com/shilohstuart6/Custom-Controller.git/api/v1alpha1"
+)
+
+const (
+	// InstanceLabel identifies the pods and other objects belonging to a
+	// single MyAppResource.
+	InstanceLabel = "app.kubernetes.io/instance"
+
+	zoneTopologyKey     = "topology.kubernetes.io/zone"
+	hostnameTopologyKey = "kubernetes.io/hostname"
+)
+
+// Labels returns the labels set on the pod template and the other rendered
+// objects, and the selector of new Deployments. Deployments created before
+// the instance label existed keep their selector, which is immutable.
+func Labels(mar myv1alpha1.MyAppResource) map[string]string {
+	return map[string]string{
+		"app":         "myappresource",
+		InstanceLabel: Instance(mar),
+	}
+}
+
+// applyScheduling copies the scheduling settings of the custom resource
+// onto the pod spec.
+func applyScheduling(spec *corev1.PodSpec, mar myv1alpha1.MyAppResource) {
+	s := mar.Spec.Scheduling
+
+	spec.NodeSelector = s.NodeSelector
+	spec.Tolerations = s.Tolerations
+	spec.Affinity = s.Affinity
+	spec.PriorityClassName = s.PriorityClassName
+	spec.RuntimeClassName = s.RuntimeClassName
+	spec.TopologySpreadConstraints = append([]corev1.TopologySpreadConstraint(nil), s.TopologySpreadConstraints...)
+
+	if !s.SpreadAcrossZones {
+		return
+	}
+	for _, key := range []string{zoneTopologyKey, hostnameTopologyKey} {
+		if hasTopologyKey(s.TopologySpreadConstraints, key) {
+			continue
+		}
+		spec.TopologySpreadConstraints = append(spec.TopologySpreadConstraints, corev1.TopologySpreadConstraint{
+			MaxSkew:           1,
+			TopologyKey:       key,
+			WhenUnsatisfiable: corev1.ScheduleAnyway,
+			LabelSelector: &metav1.LabelSelector{
+				MatchLabels: Labels(mar),
+			},
+		})
+	}
+}
+
+func hasTopologyKey(constraints []corev1.TopologySpreadConstraint, key string) bool {
+	for _, c := range constraints {
+		if c.TopologyKey == key {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,134 @@
+/*
+Copyright 2024 shiliohstuart6.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package render
+
+import (
+	"encoding/json"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	psaapi "k8s.io/pod-security-admission/api"
+	"k8s.io/pod-security-admission/policy"
+	"k8s.io/utils/ptr"
+
+	myv1alpha1 "github.com/shilohstuart6/Custom-Controller.git/api/v1alpha1"
+)
+
+// The images we generate containers for declare non-numeric users, so the
+// kubelet cannot verify runAsNonRoot without an explicit uid.
+var containerUIDs = map[string]int64{
+	podinfoContainerName: 100,
+	redisContainerName:   999,
+}
+
+// defaultUID is the uid of containers whose image we know nothing about, such
+// as the generic app container and user sidecars. Images running as root, or
+// as a named user, would otherwise fail to start under runAsNonRoot. It is the
+// "nonroot" user of the distroless images.
+const defaultUID int64 = 65532
+
+// applySecurityContext sets the restricted-compliant defaults on the pod and
+// its containers, then layers the user overrides on top. A container's own
+// security context, as given for sidecars, takes precedence over both.
+func applySecurityContext(spec *corev1.PodSpec, mar myv1alpha1.MyAppResource) error {
+	spec.SecurityContext = &corev1.PodSecurityContext{
+		RunAsNonRoot: ptr.To(true),
+		SeccompProfile: &corev1.SeccompProfile{
+			Type: corev1.SeccompProfileTypeRuntimeDefault,
+		},
+	}
+	// Make the claims mounted at podinfo's data directory and at redis'
+	// snapshot directory writable for their non-root users. The fsGroup is a
+	// supplemental group of every container, so one group covers both.
+	switch {
+	case mar.Spec.Persistence.Enabled:
+		spec.SecurityContext.FSGroup = ptr.To(containerUIDs[podinfoContainerName])
+	case mar.Spec.Redis.SnapshotClaimName != "" && RunsRedis(mar):
+		spec.SecurityContext.FSGroup = ptr.To(containerUIDs[redisContainerName])
+	}
+	if err := overlay(spec.SecurityContext, mar.Spec.SecurityContext.Pod); err != nil {
+		return fmt.Errorf("applying pod security context: %w", err)
+	}
+
+	containers := make([]*corev1.Container, 0, len(spec.InitContainers)+len(spec.Containers))
+	for i := range spec.InitContainers {
+		containers = append(containers, &spec.InitContainers[i])
+	}
+	for i := range spec.Containers {
+		containers = append(containers, &spec.Containers[i])
+	}
+	for _, c := range containers {
+		own := c.SecurityContext
+		c.SecurityContext = &corev1.SecurityContext{
+			RunAsNonRoot:             ptr.To(true),
+			AllowPrivilegeEscalation: ptr.To(false),
+			ReadOnlyRootFilesystem:   ptr.To(true),
+			Capabilities: &corev1.Capabilities{
+				Drop: []corev1.Capability{"ALL"},
+			},
+		}
+		if uid, ok := containerUIDs[c.Name]; ok {
+			c.SecurityContext.RunAsUser = ptr.To(uid)
+		} else if spec.SecurityContext.RunAsUser == nil {
+			c.SecurityContext.RunAsUser = ptr.To(defaultUID)
+		}
+		if err := overlay(c.SecurityContext, mar.Spec.SecurityContext.Container); err != nil {
+			return fmt.Errorf("applying %s container security context: %w", c.Name, err)
+		}
+		if err := overlay(c.SecurityContext, own); err != nil {
+			return fmt.Errorf("applying %s container security context: %w", c.Name, err)
+		}
+	}
+	return nil
+}
+
+// overlay copies every field that is set in src onto dst, leaving the
+// remaining fields of dst untouched.
+func overlay(dst, src interface{}) error {
+	b, err := json.Marshal(src)
+	if err != nil {
+		return err
+	}
+	if string(b) == "null" {
+		return nil
+	}
+	return json.Unmarshal(b, dst)
+}
+
+// checkPodSecurity returns an error describing every way in which the pod
+// spec violates the requested Pod Security Standard level.
+func checkPodSecurity(level string, meta metav1.ObjectMeta, spec corev1.PodSpec) error {
+	if level == "" {
+		level = string(psaapi.LevelRestricted)
+	}
+	l, err := psaapi.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+
+	evaluator, err := policy.NewEvaluator(policy.DefaultChecks())
+	if err != nil {
+		return err
+	}
+	results := evaluator.EvaluatePod(psaapi.LevelVersion{Level: l, Version: psaapi.LatestVersion()}, &meta, &spec)
+	agg := policy.AggregateCheckResults(results)
+	if !agg.Allowed {
+		return fmt.Errorf("violates PodSecurity %q: %s", l, agg.ForbiddenDetail())
+	}
+	return nil
+}
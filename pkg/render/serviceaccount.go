@@ -0,0 +1,101 @@
+/*
+Copyright 2024 shiliohstuart6.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package render
+
+import (
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	myv1alpha1 "github.com/shilohstuart6/Custom-Controller.git/api/v1alpha1"
+)
+
+// serviceAccountName returns the ServiceAccount the pods run as, or "" for
+// the namespace default.
+func serviceAccountName(mar myv1alpha1.MyAppResource) string {
+	sa := mar.Spec.ServiceAccount
+	if sa.Name == "" && sa.Create {
+		return Fullname(mar)
+	}
+	return sa.Name
+}
+
+func applyServiceAccount(spec *corev1.PodSpec, mar myv1alpha1.MyAppResource) {
+	spec.ServiceAccountName = serviceAccountName(mar)
+	spec.AutomountServiceAccountToken = mar.Spec.ServiceAccount.AutomountToken
+}
+
+func roleKind(ref myv1alpha1.RoleReference) string {
+	if ref.Kind == "" {
+		return "Role"
+	}
+	return ref.Kind
+}
+
+// roleBindingName returns the name of the RoleBinding granting ref to the
+// ServiceAccount of the custom resource. The kind is part of the name since
+// the role reference of a RoleBinding cannot be changed.
+func roleBindingName(mar myv1alpha1.MyAppResource, ref myv1alpha1.RoleReference) string {
+	return Name(mar, strings.ToLower(roleKind(ref)), ref.Name)
+}
+
+// serviceAccountObjects returns the ServiceAccount and RoleBindings of the
+// custom resource, if it asks for a ServiceAccount to be created.
+func serviceAccountObjects(mar myv1alpha1.MyAppResource) []client.Object {
+	spec := mar.Spec.ServiceAccount
+	if !spec.Create {
+		return nil
+	}
+	name := serviceAccountName(mar)
+
+	objs := []client.Object{
+		&corev1.ServiceAccount{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Namespace:   mar.Namespace,
+				Labels:      Labels(mar),
+				Annotations: spec.Annotations,
+			},
+			AutomountServiceAccountToken: spec.AutomountToken,
+		},
+	}
+	for _, ref := range spec.Roles {
+		objs = append(objs, &rbacv1.RoleBinding{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      roleBindingName(mar, ref),
+				Namespace: mar.Namespace,
+				Labels:    Labels(mar),
+			},
+			RoleRef: rbacv1.RoleRef{
+				APIGroup: rbacv1.GroupName,
+				Kind:     roleKind(ref),
+				Name:     ref.Name,
+			},
+			Subjects: []rbacv1.Subject{
+				{
+					Kind:      rbacv1.ServiceAccountKind,
+					Name:      name,
+					Namespace: mar.Namespace,
+				},
+			},
+		})
+	}
+	return objs
+}
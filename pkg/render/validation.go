@@ -0,0 +1,298 @@
+/*
+Copyright 2024 shiliohstuart6.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package render
+
+import (
+	"errors"
+	"net/url"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+
+	myv1alpha1 "github.com/shilohstuart6/Custom-Controller.git/api/v1alpha1"
+)
+
+// Validate renders the custom resource with the profile it selects, applies
+// its overrides and reports every problem with the result. It is used by the admission
+// webhook so invalid specs are rejected before they are stored.
+func Validate(mar myv1alpha1.MyAppResource, opts Options) field.ErrorList {
+	var errs field.ErrorList
+	specPath := field.NewPath("spec")
+	mar = withDefaults(mar, opts)
+
+	profile, err := ForResource(mar)
+	if err != nil {
+		return append(errs, field.NotSupported(specPath.Child("profile"), mar.Spec.Profile, Profiles()))
+	}
+
+	resourcesPath := specPath.Child("resources")
+	for _, q := range []struct {
+		name  string
+		value string
+	}{
+		{"memoryRequest", mar.Spec.Resources.MemoryRequest},
+		{"memoryLimit", mar.Spec.Resources.MemoryLimit},
+		{"cpuRequest", mar.Spec.Resources.CpuRequest},
+		{"cpuLimit", mar.Spec.Resources.CpuLimit},
+	} {
+		if q.value == "" {
+			continue
+		}
+		if _, err := resource.ParseQuantity(q.value); err != nil {
+			errs = append(errs, field.Invalid(resourcesPath.Child(q.name), q.value, err.Error()))
+		}
+	}
+
+	envPath := specPath.Child("env")
+	seen := map[string]bool{}
+	for i, env := range mar.Spec.Env {
+		namePath := envPath.Index(i).Child("name")
+		switch {
+		case reservedEnv[env.Name]:
+			errs = append(errs, field.Forbidden(namePath, env.Name+" is managed by the controller"))
+		case seen[env.Name]:
+			errs = append(errs, field.Duplicate(namePath, env.Name))
+		}
+		seen[env.Name] = true
+	}
+
+	errs = append(errs, validateNaming(mar, specPath)...)
+	errs = append(errs, validateVolumes(mar, specPath)...)
+	errs = append(errs, validateApp(mar, specPath)...)
+	errs = append(errs, validateRedis(mar, specPath)...)
+	errs = append(errs, validateContainers(mar, specPath, opts)...)
+	if v, ok := profile.(Validator); ok {
+		errs = append(errs, v.Validate(mar, specPath)...)
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	objs, err := profile.Render(mar, opts)
+	if err != nil {
+		return append(errs, field.InternalError(specPath, err))
+	}
+	if _, err := ApplyOverrides(mar, objs); err != nil {
+		var oe *overrideError
+		if errors.As(err, &oe) {
+			return append(errs, field.Invalid(specPath.Child("overrides").Index(oe.index), mar.Spec.Overrides[oe.index].Target.Kind, oe.err.Error()))
+		}
+		return append(errs, field.InternalError(specPath.Child("overrides"), err))
+	}
+	if errs := validateObjectNames(mar, objs, specPath); len(errs) > 0 {
+		return errs
+	}
+
+	level := mar.Spec.SecurityContext.PodSecurityLevel
+	for _, obj := range objs {
+		d, ok := obj.(*appsv1.Deployment)
+		if !ok {
+			continue
+		}
+		if err := checkPodSecurity(level, d.Spec.Template.ObjectMeta, d.Spec.Template.Spec); err != nil {
+			errs = append(errs, field.Forbidden(specPath.Child("securityContext"), err.Error()))
+		}
+	}
+
+	return errs
+}
+
+func validateVolumes(mar myv1alpha1.MyAppResource, specPath *field.Path) field.ErrorList {
+	var errs field.ErrorList
+
+	volumes := map[string]bool{}
+	for name := range builtinVolumes {
+		volumes[name] = true
+	}
+	volumesPath := specPath.Child("volumes")
+	for i, v := range mar.Spec.Volumes {
+		path := volumesPath.Index(i)
+		if volumes[v.Name] {
+			errs = append(errs, field.Duplicate(path.Child("name"), v.Name))
+		}
+		volumes[v.Name] = true
+
+		sources := 0
+		for _, set := range []bool{
+			v.ConfigMap != nil, v.Secret != nil, v.EmptyDir != nil,
+			v.Projected != nil, v.PersistentVolumeClaim != nil,
+		} {
+			if set {
+				sources++
+			}
+		}
+		if sources != 1 {
+			errs = append(errs, field.Invalid(path, v.Name, "exactly one volume source must be set"))
+		}
+	}
+
+	mountPaths := map[string]bool{"/tmp": true}
+	if mar.Spec.Persistence.Enabled {
+		mountPaths[podinfoDataPath] = true
+	}
+	mountsPath := specPath.Child("volumeMounts")
+	for i, m := range mar.Spec.VolumeMounts {
+		path := mountsPath.Index(i)
+		if !volumes[m.Name] {
+			errs = append(errs, field.NotFound(path.Child("name"), m.Name))
+		}
+		if mountPaths[m.MountPath] {
+			errs = append(errs, field.Duplicate(path.Child("mountPath"), m.MountPath))
+		}
+		mountPaths[m.MountPath] = true
+	}
+
+	for _, extra := range []struct {
+		name       string
+		containers []corev1.Container
+	}{
+		{"sidecars", mar.Spec.Sidecars},
+		{"initContainers", mar.Spec.InitContainers},
+	} {
+		for i, c := range extra.containers {
+			for j, m := range c.VolumeMounts {
+				if !volumes[m.Name] {
+					errs = append(errs, field.NotFound(specPath.Child(extra.name).Index(i).Child("volumeMounts").Index(j).Child("name"), m.Name))
+				}
+			}
+		}
+	}
+
+	if size := mar.Spec.Persistence.Size; size != "" {
+		if _, err := resource.ParseQuantity(size); err != nil {
+			errs = append(errs, field.Invalid(specPath.Child("persistence", "size"), size, err.Error()))
+		}
+	}
+
+	return errs
+}
+
+func validatePodinfo(mar myv1alpha1.MyAppResource, specPath *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	p := mar.Spec.Podinfo
+	path := specPath.Child("podinfo")
+
+	ports := map[int32]string{}
+	if RunsRedis(mar) {
+		ports[redisPort] = "redis"
+	}
+	for _, port := range []struct {
+		name  string
+		value int32
+	}{
+		{"httpPort", httpPort(mar)},
+		{"grpcPort", p.GRPCPort},
+		{"securePort", p.SecurePort},
+	} {
+		if port.value == 0 {
+			continue
+		}
+		if other, ok := ports[port.value]; ok {
+			errs = append(errs, field.Invalid(path.Child(port.name), port.value, "already used by "+other))
+		}
+		ports[port.value] = port.name
+	}
+
+	if p.SecurePort != 0 && p.CertPath == "" {
+		errs = append(errs, field.Required(path.Child("certPath"), "required when securePort is set"))
+	}
+
+	for i, u := range p.BackendURLs {
+		if parsed, err := url.Parse(u); err != nil || parsed.Scheme == "" || parsed.Host == "" {
+			errs = append(errs, field.Invalid(path.Child("backendURLs").Index(i), u, "must be an absolute URL"))
+		}
+	}
+
+	if d := p.RandomDelay; d != nil && d.Min > d.Max {
+		errs = append(errs, field.Invalid(path.Child("randomDelay", "min"), d.Min, "must not exceed max"))
+	}
+
+	return errs
+}
+
+func validateApp(mar myv1alpha1.MyAppResource, specPath *field.Path) field.ErrorList {
+	var errs field.ErrorList
+
+	names := map[string]bool{}
+	numbers := map[int32]bool{}
+	if RunsRedis(mar) {
+		numbers[redisPort] = true
+	}
+	portsPath := specPath.Child("app", "ports")
+	for i, p := range mar.Spec.App.Ports {
+		path := portsPath.Index(i)
+		switch {
+		case p.Name == "":
+			// The Service and NetworkPolicies refer to ports by name.
+			errs = append(errs, field.Required(path.Child("name"), "ports must be named"))
+		case names[p.Name]:
+			errs = append(errs, field.Duplicate(path.Child("name"), p.Name))
+		}
+		if numbers[p.ContainerPort] {
+			errs = append(errs, field.Duplicate(path.Child("containerPort"), p.ContainerPort))
+		}
+		names[p.Name] = true
+		numbers[p.ContainerPort] = true
+	}
+
+	return errs
+}
+
+// validateContainers checks that the sidecars and init containers have
+// names of their own, and only use native sidecars where the cluster runs
+// them.
+func validateContainers(mar myv1alpha1.MyAppResource, specPath *field.Path, opts Options) field.ErrorList {
+	var errs field.ErrorList
+
+	names := map[string]bool{}
+	check := func(path *field.Path, c corev1.Container) {
+		namePath := path.Child("name")
+		switch {
+		case c.Name == "":
+			errs = append(errs, field.Required(namePath, ""))
+		case reservedContainerNames[c.Name]:
+			errs = append(errs, field.Forbidden(namePath, c.Name+" is used by the controller"))
+		case names[c.Name]:
+			errs = append(errs, field.Duplicate(namePath, c.Name))
+		}
+		names[c.Name] = true
+		if c.Image == "" {
+			errs = append(errs, field.Required(path.Child("image"), ""))
+		}
+	}
+
+	for i, c := range mar.Spec.Sidecars {
+		path := specPath.Child("sidecars").Index(i)
+		check(path, c)
+		if c.RestartPolicy != nil {
+			errs = append(errs, field.Forbidden(path.Child("restartPolicy"), "set by the controller"))
+		}
+	}
+	for i, c := range mar.Spec.InitContainers {
+		path := specPath.Child("initContainers").Index(i)
+		check(path, c)
+		if c.RestartPolicy != nil && !opts.NativeSidecars {
+			errs = append(errs, field.Forbidden(path.Child("restartPolicy"),
+				"native sidecars need Kubernetes 1.29 or later, use spec.sidecars instead"))
+		}
+	}
+
+	return errs
+}
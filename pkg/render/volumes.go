@@ -0,0 +1,115 @@
+/*
+Copyright 2024 shiliohstuart6.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package render
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	myv1alpha1 "github.com/shilohstuart6/Custom-Controller.git/api/v1alpha1"
+)
+
+const (
+	// persistentDataVolume is the pod volume backed by the claim the
+	// controller creates for spec.persistence.
+	persistentDataVolume   = "podinfo-data"
+	podinfoDataPath        = "/data"
+	defaultPersistenceSize = "1Gi"
+)
+
+// builtinVolumes are the volumes the controller adds to every pod.
+var builtinVolumes = map[string]bool{
+	"conf":               true,
+	"data":               true,
+	"tmp":                true,
+	persistentDataVolume: true,
+	redisSnapshotVolume:  true,
+}
+
+func persistentVolumeClaimName(mar myv1alpha1.MyAppResource) string {
+	return Name(mar, "data")
+}
+
+// applyVolumes adds the user-supplied volumes to the pod, mounts them into
+// the main container, and mounts the controller-owned claim when
+// persistence is enabled.
+func applyVolumes(spec *corev1.PodSpec, mar myv1alpha1.MyAppResource, main string) {
+	for _, v := range mar.Spec.Volumes {
+		spec.Volumes = append(spec.Volumes, corev1.Volume{
+			Name: v.Name,
+			VolumeSource: corev1.VolumeSource{
+				ConfigMap:             v.ConfigMap,
+				Secret:                v.Secret,
+				EmptyDir:              v.EmptyDir,
+				Projected:             v.Projected,
+				PersistentVolumeClaim: v.PersistentVolumeClaim,
+			},
+		})
+	}
+
+	c := findContainer(spec, main)
+	if c == nil {
+		return
+	}
+	c.VolumeMounts = append(c.VolumeMounts, mar.Spec.VolumeMounts...)
+
+	if !mar.Spec.Persistence.Enabled {
+		return
+	}
+	spec.Volumes = append(spec.Volumes, corev1.Volume{
+		Name: persistentDataVolume,
+		VolumeSource: corev1.VolumeSource{
+			PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+				ClaimName: persistentVolumeClaimName(mar),
+			},
+		},
+	})
+	c.VolumeMounts = append(c.VolumeMounts, corev1.VolumeMount{
+		Name:      persistentDataVolume,
+		MountPath: podinfoDataPath,
+	})
+}
+
+// persistentVolumeClaim returns the claim backing podinfo's data directory.
+func persistentVolumeClaim(mar myv1alpha1.MyAppResource) (*corev1.PersistentVolumeClaim, error) {
+	p := mar.Spec.Persistence
+	size, err := quantity(p.Size, defaultPersistenceSize)
+	if err != nil {
+		return nil, fmt.Errorf("parsing persistence size: %w", err)
+	}
+
+	pvc := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      persistentVolumeClaimName(mar),
+			Namespace: mar.Namespace,
+			Labels:    Labels(mar),
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			StorageClassName: p.StorageClassName,
+			AccessModes:      p.AccessModes,
+			Resources: corev1.VolumeResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceStorage: size},
+			},
+		},
+	}
+	if len(pvc.Spec.AccessModes) == 0 {
+		pvc.Spec.AccessModes = []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce}
+	}
+	return pvc, nil
+}
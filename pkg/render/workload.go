@@ -0,0 +1,184 @@
+/*
+Copyright 2024 shiliohstuart6.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package render
+
+import (
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	myv1alpha1 "github.com/shilohstuart6/Custom-Controller.git/api/v1alpha1"
+)
+
+// Workload is the pod a profile runs for a MyAppResource.
+type Workload struct {
+	// Containers are the containers of the pod, in order.
+	Containers []corev1.Container
+	// Main is the name of the container running the application. It
+	// receives spec.env, spec.envFrom and spec.volumeMounts, and its ports
+	// are exposed by the Service and NetworkPolicy.
+	Main string
+}
+
+// Build renders the Deployment running the workload along with the objects
+// every profile shares: the ServiceAccount and RoleBindings, the
+// NetworkPolicies, the data PersistentVolumeClaim and the Service. Profiles
+// build their containers and leave the rest of the spec, including the
+// user's sidecars and init containers, to Build.
+func Build(mar myv1alpha1.MyAppResource, w Workload, opts Options) ([]client.Object, error) {
+	d := deployment(mar, w.Containers)
+	spec := &d.Spec.Template.Spec
+
+	applyScheduling(spec, mar)
+	applyServiceAccount(spec, mar)
+	if err := applyEnv(spec, mar, w.Main); err != nil {
+		return nil, err
+	}
+	applyVolumes(spec, mar, w.Main)
+	applyRedisSnapshot(spec, mar)
+	if err := applyExtraContainers(spec, mar, opts); err != nil {
+		return nil, err
+	}
+	if err := applySecurityContext(spec, mar); err != nil {
+		return nil, err
+	}
+
+	var ports []corev1.ContainerPort
+	if c := findContainer(spec, w.Main); c != nil {
+		ports = c.Ports
+	}
+
+	var objs []client.Object
+	objs = append(objs, serviceAccountObjects(mar)...)
+	objs = append(objs, networkPolicies(mar, ports, findContainer(spec, redisContainerName) != nil)...)
+	if mar.Spec.Persistence.Enabled {
+		pvc, err := persistentVolumeClaim(mar)
+		if err != nil {
+			return nil, err
+		}
+		objs = append(objs, pvc)
+	}
+	if len(ports) > 0 {
+		objs = append(objs, service(mar, ports))
+	}
+	return append(objs, d), nil
+}
+
+// Resources returns the compute resources of spec.resources, with defaults
+// for the quantities left empty.
+func Resources(mar myv1alpha1.MyAppResource) (corev1.ResourceRequirements, error) {
+	r := mar.Spec.Resources
+	memR, err := quantity(r.MemoryRequest, "32Mi")
+	if err != nil {
+		return corev1.ResourceRequirements{}, fmt.Errorf("parsing memory request: %w", err)
+	}
+	memL, err := quantity(r.MemoryLimit, "64Mi")
+	if err != nil {
+		return corev1.ResourceRequirements{}, fmt.Errorf("parsing memory limit: %w", err)
+	}
+	cpuR, err := quantity(r.CpuRequest, "100m")
+	if err != nil {
+		return corev1.ResourceRequirements{}, fmt.Errorf("parsing cpu request: %w", err)
+	}
+	cpuL, err := quantity(r.CpuLimit, "200m")
+	if err != nil {
+		return corev1.ResourceRequirements{}, fmt.Errorf("parsing cpu limit: %w", err)
+	}
+
+	return corev1.ResourceRequirements{
+		Limits: corev1.ResourceList{
+			corev1.ResourceCPU:    cpuL,
+			corev1.ResourceMemory: memL,
+		},
+		Requests: corev1.ResourceList{
+			corev1.ResourceCPU:    cpuR,
+			corev1.ResourceMemory: memR,
+		},
+	}, nil
+}
+
+func quantity(value, fallback string) (resource.Quantity, error) {
+	if value == "" {
+		value = fallback
+	}
+	return resource.ParseQuantity(value)
+}
+
+// Image returns the image reference of spec.image.
+func Image(mar myv1alpha1.MyAppResource) string {
+	return mar.Spec.Image.Repository + ":" + mar.Spec.Image.Tag
+}
+
+func deployment(mar myv1alpha1.MyAppResource, containers []corev1.Container) *appsv1.Deployment {
+	return &appsv1.Deployment{
+		TypeMeta: metav1.TypeMeta{Kind: "Deployment"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      Fullname(mar),
+			Namespace: mar.Namespace,
+			Labels:    Labels(mar),
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &mar.Spec.ReplicaCount,
+			Selector: &metav1.LabelSelector{
+				MatchLabels: Labels(mar),
+			},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: Labels(mar),
+				},
+				Spec: corev1.PodSpec{
+					RestartPolicy: corev1.RestartPolicyAlways,
+					Containers:    containers,
+					Volumes: []corev1.Volume{
+						{
+							Name: "conf",
+							VolumeSource: corev1.VolumeSource{
+								EmptyDir: &corev1.EmptyDirVolumeSource{},
+							},
+						},
+						{
+							Name: "data",
+							VolumeSource: corev1.VolumeSource{
+								EmptyDir: &corev1.EmptyDirVolumeSource{},
+							},
+						},
+						{
+							Name: "tmp",
+							VolumeSource: corev1.VolumeSource{
+								EmptyDir: &corev1.EmptyDirVolumeSource{},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// findContainer returns the container with the given name, or nil.
+func findContainer(spec *corev1.PodSpec, name string) *corev1.Container {
+	for i := range spec.Containers {
+		if spec.Containers[i].Name == name {
+			return &spec.Containers[i]
+		}
+	}
+	return nil
+}